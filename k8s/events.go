@@ -0,0 +1,86 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"log-collect/tools"
+
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Events events of namespace sorted by time, pods: only events involving these pods (nil: all);
+// window: only events last seen in the window
+func Events(c *kubernetes.Clientset, ns string, pods []string, window tools.TimeWindow) (*coreV1.EventList, error) {
+	var list *coreV1.EventList
+	err := tools.Retry(fmt.Sprintf("%v list events", ns), func() error {
+		var err error
+		list, err = c.CoreV1().Events(ns).List(context.TODO(), metaV1.ListOptions{})
+		return apiError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	podSet := make(map[string]bool)
+	for _, pod := range pods {
+		podSet[pod] = true
+	}
+	events := &coreV1.EventList{TypeMeta: metaV1.TypeMeta{Kind: "EventList", APIVersion: "v1"}}
+	for _, event := range list.Items {
+		if pods != nil && (event.InvolvedObject.Kind != "Pod" || !podSet[event.InvolvedObject.Name]) {
+			continue
+		}
+		if !window.IsZero() && !window.Contains(eventTime(event)) {
+			continue
+		}
+		event.ManagedFields = nil
+		events.Items = append(events.Items, event)
+	}
+	sort.SliceStable(events.Items, func(i, j int) bool {
+		return eventTime(events.Items[i]).Before(eventTime(events.Items[j]))
+	})
+	return events, nil
+}
+
+// eventTime 最后一次发生的时间
+func eventTime(event coreV1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+	return event.FirstTimestamp.Time
+}
+
+// WriteObject 把 k8s 对象保存为 yaml 或 json(format=json), 内容经过 stream 的过滤(脱敏等)
+func WriteObject(obj interface{}, format, destFile string, stream tools.Stream) (int64, error) {
+	var data []byte
+	var err error
+	if format == "json" {
+		data, err = json.MarshalIndent(obj, "", "  ")
+	} else {
+		data, err = yaml.Marshal(obj)
+	}
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.Create(destFile)
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(file, stream.Reader(bytes.NewReader(data)))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return size, err
+}
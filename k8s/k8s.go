@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,16 +13,18 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/juju/ratelimit"
 	coreV1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
+	utilExec "k8s.io/client-go/util/exec"
 	cmdUtil "k8s.io/kubectl/pkg/cmd/util"
 )
 
@@ -176,19 +179,12 @@ func unTarAll(reader io.Reader, destDir, prefix string) error {
 }
 
 // unTarAll1 4K per read
-func unTarAll1(reader io.Reader, destDir string, limit int) error {
+func unTarAll1(reader io.Reader, destDir string) error {
 	buf := bufio.NewReader(reader)
 	outFile, _ := os.Create(destDir)
 	w := bufio.NewWriter(outFile)
 	s := make([]byte, 40960)
 
-	// Bucket adding limit MB every second, limit MB
-	bucket := ratelimit.NewBucketWithRate(float64(limit*1024000), int64(limit*1024000))
-	if limit == 0 {
-		// max 10G ~= unlimited
-		bucket = ratelimit.NewBucketWithRate(10000*1024000, 10000*1024000)
-	}
-
 	start := time.Now()
 	for {
 		_, err := buf.Read(s)
@@ -199,16 +195,15 @@ func unTarAll1(reader io.Reader, destDir string, limit int) error {
 			break
 		}
 		// Copy source to destination, but wrap our reader with rate limited one
-		io.Copy(w, ratelimit.Reader(reader, bucket))
+		io.Copy(w, tools.LimitReader(reader))
 	}
 	log.Println("Copied in ", time.Since(start))
 	defer outFile.Close()
 	return nil
 }
 
-// CopyFromPod 从 pod 复制文件到本地
-func CopyFromPod(r *rest.Config, c *kubernetes.Clientset, pod, ns, srcPathStr, dest, container string, isTar bool) error {
-	reader, outStream := io.Pipe()
+// CopyFromPod 从 pod 复制文件到本地, 返回下载的字节数; codec 不为空时在 pod 中压缩后传输
+func CopyFromPod(r *rest.Config, c *kubernetes.Clientset, pod, ns, srcPathStr, dest, container string, isTar bool, total int64, codec *tools.Codec, stream tools.Stream) (int64, error) {
 	srcPathList := strings.Split(srcPathStr, "/")
 	srcPath := ""
 	srcFile := ""
@@ -219,31 +214,170 @@ func CopyFromPod(r *rest.Config, c *kubernetes.Clientset, pod, ns, srcPathStr, d
 		srcPath = strings.Join(srcPathList[0:len(srcPathList)-1], "/")
 		srcFile = srcPathList[len(srcPathList)-1]
 	}
+	isDir := srcPathList[len(srcPathList)-1] == ""
+	if !isDir {
+		_, err := Exec(r, c, pod, ns, "test -d "+tools.ShellQuote(srcPathStr), container)
+		isDir = err == nil
+	}
+	// 过滤只能处理单个文件的内容, 目录中的文件逐个下载
+	if stream.Filtered() && isDir {
+		return copyDirFromPod(r, c, pod, ns, container, srcPathStr, dest+"/"+pod+"/"+srcFile, codec, stream)
+	}
+	// tar 只用于目录, 单个文件使用 cat 下载, 可以校验
+	isTar = isTar && isDir && !stream.Filtered()
 	var cmd []string
+	ext := ""
 	if isTar {
 		cmd = []string{"tar", "cf", "-", srcPathStr, "--warning=no-file-changed"}
+		ext = ".tar"
+		if codec != nil {
+			cmd = []string{"sh", "-c", fmt.Sprintf("tar cf - %v %v --warning=no-file-changed",
+				codec.TarOpt, tools.ShellQuote(srcPathStr))}
+			ext += codec.Ext
+		}
 	} else {
-		if srcPathList[len(srcPathList)-1] == "" {
+		if isDir {
 			cmd := "ls " + srcPathStr
 			res, err := Exec(r, c, pod, ns, cmd, container)
 			if err != nil {
-				return &tools.NewError{Msg: res}
+				return 0, &tools.NewError{Msg: res}
 			}
 			msg := "There is no tar command in the container. Directories are not supported: " + srcPathStr
-			return &tools.NewError{Msg: msg}
+			return 0, &tools.NewError{Msg: msg}
 		}
 		cmd = []string{"cat", srcPathStr}
+		if codec != nil {
+			cmd = []string{"sh", "-c", codec.Cmd + " -- " + tools.ShellQuote(srcPathStr)}
+			if stream.Filtered() {
+				// 解压后再过滤
+				stream = stream.WithDecoder(codec.Decoder())
+			} else {
+				ext = codec.Ext
+			}
+		}
 	}
 	destPath := dest + "/" + pod
 	tools.Mkdir(destPath)
-	var destFile string
-	if cmd[0] == "cat" {
-		destFile = destPath + "/" + srcFile
-	} else {
-		destFile = destPath + "/" + srcFile + ".tar.gz"
-	}
+	destFile := destPath + "/" + srcFile + ext
 	msg := fmt.Sprintf("[INFO] Download %s/%s %s", srcPath, srcFile, destFile)
 	log.Println(msg)
+	return copyFromPod(r, c, pod, ns, container, srcPathStr, cmd, destFile, total, stream)
+}
+
+// copyDirFromPod 逐个下载目录中的文件, 保持目录结构
+func copyDirFromPod(r *rest.Config, c *kubernetes.Clientset, pod, ns, container, srcDir, destDir string, codec *tools.Codec, stream tools.Stream) (int64, error) {
+	srcDir = strings.TrimRight(srcDir, "/")
+	result, err := Exec(r, c, pod, ns, "find "+tools.ShellQuote(srcDir)+" -type f", container)
+	if err != nil {
+		return 0, &tools.NewError{Msg: result + err.Error()}
+	}
+	var total int64
+	for _, file := range strings.Split(result, "\n") {
+		if file == "" {
+			continue
+		}
+		cmd := []string{"cat", file}
+		fileStream := stream.File(file)
+		if codec != nil {
+			cmd = []string{"sh", "-c", codec.Cmd + " -- " + tools.ShellQuote(file)}
+			fileStream = fileStream.WithDecoder(codec.Decoder())
+		}
+		destFile := filepath.Join(destDir, strings.TrimPrefix(file, srcDir+"/"))
+		tools.Mkdir(filepath.Dir(destFile))
+		log.Printf("[INFO] Download %s %s", file, destFile)
+		size, err := copyFromPod(r, c, pod, ns, container, file, cmd, destFile, 0, fileStream)
+		total += size
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// copyFromPod 执行 cmd 下载 srcPathStr, cat 下载的未过滤文件与 pod 中的文件对比大小和 sha256
+func copyFromPod(r *rest.Config, c *kubernetes.Clientset, pod, ns, container, srcPathStr string, cmd []string, destFile string, total int64, stream tools.Stream) (int64, error) {
+	verify := cmd[0] == "cat" && !stream.Filtered()
+	remoteSize := int64(-1)
+	if verify {
+		// 下载前的大小, 日志文件在下载过程中还可能继续写入
+		remoteSize = podFileSize(r, c, pod, ns, container, srcPathStr)
+	}
+	var size int64
+	err := tools.Retry(fmt.Sprintf("%v download %v", pod, srcPathStr), func() error {
+		var err error
+		transfer := tools.NewTransfer(pod+":"+srcPathStr, total)
+		defer transfer.Done()
+		size, err = streamToFile(r, c, pod, ns, container, cmd, destFile, transfer, stream)
+		if err != nil || !verify {
+			// tar 流只能依赖命令的返回码
+			return err
+		}
+		if err = verifyFromPod(r, c, pod, ns, container, srcPathStr, destFile, remoteSize); err != nil {
+			_ = os.Remove(destFile)
+		}
+		return err
+	})
+	return size, err
+}
+
+// DownloadCommand 在 pod 中执行 shell 命令, 标准输出保存到 destFile, 返回下载的字节数
+func DownloadCommand(r *rest.Config, c *kubernetes.Clientset, pod, ns, container, cmd, destFile string, stream tools.Stream) (int64, error) {
+	tools.Mkdir(path.Dir(destFile))
+	var size int64
+	err := tools.Retry(fmt.Sprintf("%v run %v", pod, cmd), func() error {
+		var err error
+		transfer := tools.NewTransfer(pod+":"+cmd, 0)
+		defer transfer.Done()
+		size, err = streamToFile(r, c, pod, ns, container, []string{"sh", "-c", cmd}, destFile, transfer, stream)
+		return err
+	})
+	return size, err
+}
+
+// verifyFromPod 本地文件不能小于下载前 pod 中文件的大小(remoteSize, -1 不校验);
+// 前 N 字节(N=本地文件大小)对比 sha256, -verify=false 时只校验大小
+func verifyFromPod(r *rest.Config, c *kubernetes.Clientset, pod, ns, container, srcPath, destFile string, remoteSize int64) error {
+	local, err := os.Stat(destFile)
+	if err != nil {
+		return err
+	}
+	if local.Size() < remoteSize {
+		return &tools.NewError{Msg: fmt.Sprintf("size mismatch: local %v, remote %v", local.Size(), remoteSize)}
+	}
+	if !tools.Verify {
+		return nil
+	}
+	cmd := fmt.Sprintf("head -c %d %v | sha256sum", local.Size(), tools.ShellQuote(srcPath))
+	result, err := Exec(r, c, pod, ns, cmd, container)
+	remoteSum := tools.ParseSHA256(result)
+	if err != nil || remoteSum == "" {
+		log.Printf("[WARN] %v sha256sum not available, skip verify: %v", pod, srcPath)
+		return nil
+	}
+	localSum, err := tools.FileSHA256(destFile)
+	if err != nil {
+		return err
+	}
+	if localSum != remoteSum {
+		return &tools.NewError{Msg: fmt.Sprintf("sha256 mismatch: local %v, remote %v", localSum, remoteSum)}
+	}
+	return nil
+}
+
+// podFileSize 文件大小, wc 不可用时返回 -1, 不校验大小
+func podFileSize(r *rest.Config, c *kubernetes.Clientset, pod, ns, container, srcPath string) int64 {
+	result, err := Exec(r, c, pod, ns, "wc -c < "+tools.ShellQuote(srcPath), container)
+	size, parseErr := strconv.ParseInt(strings.TrimSpace(result), 10, 64)
+	if err != nil || parseErr != nil {
+		log.Printf("[WARN] %v wc not available, skip size verify: %v", pod, srcPath)
+		return -1
+	}
+	return size
+}
+
+// streamToFile 执行命令并把标准输出写入本地文件
+func streamToFile(r *rest.Config, c *kubernetes.Clientset, pod, ns, container string, cmd []string, destFile string, transfer *tools.Transfer, stream tools.Stream) (int64, error) {
+	reader, outStream := io.Pipe()
 	// 初始化pod所在的 coreV1 资源组，发送请求
 	req := c.CoreV1().RESTClient().Get().
 		Resource("pods").
@@ -263,28 +397,44 @@ func CopyFromPod(r *rest.Config, c *kubernetes.Clientset, pod, ns, srcPathStr, d
 	// remote-command 主要实现了http 转 SPDY 添加X-Stream-Protocol-Version相关header 并发送请求
 	exec, err := remotecommand.NewSPDYExecutor(r, "POST", req.URL())
 	if err != nil {
-		return err
+		return 0, err
 	}
 	go func() {
-		defer outStream.Close()
 		var errStream bytes.Buffer
-		err = exec.Stream(remotecommand.StreamOptions{
-			Stdin:  os.Stdin,
+		err := exec.Stream(remotecommand.StreamOptions{
+			Stdin:  strings.NewReader(""),
 			Stdout: outStream,
 			Stderr: &errStream,
 			Tty:    false,
 		})
 		if err != nil {
-			log.Println("[ERROR]: ", errStream.String())
+			err = execError(err, errStream.String())
 		}
+		// 把执行错误传给读取端, 避免不完整的文件被当成成功
+		_ = outStream.CloseWithError(err)
 	}()
 
-	err = tools.LimitDownload(reader, destFile)
-	if err != nil {
-		return err
+	size, err := tools.LimitDownload(transfer.Reader(reader), destFile, stream.WithBucket(tools.HostBucket(pod)))
+	_ = reader.Close()
+	return size, err
+}
+
+// execError 命令已执行但返回码非0时不重试
+func execError(err error, stderr string) error {
+	err = tools.WithStderr(err, stderr)
+	var exitErr utilExec.ExitError
+	if errors.As(err, &exitErr) {
+		return tools.NoRetry(err)
 	}
+	return err
+}
 
-	return nil
+// apiError 请求错误, 资源不存在, 没有权限等 api 返回的错误不重试
+func apiError(err error) error {
+	if apiErrors.IsBadRequest(err) || apiErrors.IsNotFound(err) || apiErrors.IsForbidden(err) || apiErrors.IsUnauthorized(err) {
+		return tools.NoRetry(err)
+	}
+	return err
 }
 
 func CopyToPod(r *rest.Config, c *kubernetes.Clientset) error {
@@ -426,11 +576,21 @@ func Exec(r *rest.Config, c *kubernetes.Clientset, podName, namespace, cmd, cont
 	}
 	// 使用bytes.Buffer变量接收标准输出和标准错误
 	var stdout, stderr bytes.Buffer
-	if err = executor.Stream(remotecommand.StreamOptions{
-		Stdin:  strings.NewReader(""),
-		Stdout: &stdout,
-		Stderr: &stderr,
-	}); err != nil {
+	err = tools.Retry(fmt.Sprintf("%v exec %v", podName, cmd), func() error {
+		stdout.Reset()
+		stderr.Reset()
+		err := executor.Stream(remotecommand.StreamOptions{
+			Stdin:  strings.NewReader(""),
+			Stdout: &stdout,
+			Stderr: &stderr,
+		})
+		var exitErr utilExec.ExitError
+		if errors.As(err, &exitErr) {
+			return tools.NoRetry(err)
+		}
+		return err
+	})
+	if err != nil {
 		return stderr.String(), err
 	}
 	result := stdout.String()
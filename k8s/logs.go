@@ -0,0 +1,71 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"log-collect/tools"
+
+	coreV1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodContainers all containers of pod, init containers first
+func PodContainers(c *kubernetes.Clientset, pod, ns string) ([]string, error) {
+	podInfo, err := c.CoreV1().Pods(ns).Get(context.TODO(), pod, metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var containers []string
+	for _, container := range podInfo.Spec.InitContainers {
+		containers = append(containers, container.Name)
+	}
+	for _, container := range podInfo.Spec.Containers {
+		containers = append(containers, container.Name)
+	}
+	return containers, nil
+}
+
+// DefaultContainer 同 kubectl logs: kubectl.kubernetes.io/default-container 注解指定的容器, 否则第一个容器
+func DefaultContainer(c *kubernetes.Clientset, pod, ns string) (string, error) {
+	podInfo, err := c.CoreV1().Pods(ns).Get(context.TODO(), pod, metaV1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if name := podInfo.Annotations["kubectl.kubernetes.io/default-container"]; name != "" {
+		for _, container := range podInfo.Spec.Containers {
+			if container.Name == name {
+				return name, nil
+			}
+		}
+	}
+	if len(podInfo.Spec.Containers) == 0 {
+		return "", &tools.NewError{Msg: "no container in pod " + pod}
+	}
+	return podInfo.Spec.Containers[0].Name, nil
+}
+
+// NoPreviousLogs 容器没有重启过, 没有上一次运行的日志
+func NoPreviousLogs(err error) bool {
+	return apiErrors.IsBadRequest(err) && strings.Contains(err.Error(), "previous terminated container")
+}
+
+// PodLogs 使用 api 拉取容器日志(kubectl logs), 保存到 destFile
+func PodLogs(c *kubernetes.Clientset, pod, ns string, opts *coreV1.PodLogOptions, destFile string, stream tools.Stream) (int64, error) {
+	var size int64
+	err := tools.Retry(fmt.Sprintf("%v logs %v", pod, opts.Container), func() error {
+		reader, err := c.CoreV1().Pods(ns).GetLogs(pod, opts).Stream(context.TODO())
+		if err != nil {
+			return apiError(err)
+		}
+		defer reader.Close()
+		transfer := tools.NewTransfer(pod+":"+opts.Container, 0)
+		defer transfer.Done()
+		size, err = tools.LimitDownload(transfer.Reader(reader), destFile, stream.WithBucket(tools.HostBucket(pod)))
+		return err
+	})
+	return size, err
+}
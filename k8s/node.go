@@ -0,0 +1,56 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"log-collect/tools"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Nodes names of nodes matching the name regexp and label selector
+func Nodes(c *kubernetes.Clientset, match *regexp.Regexp, selector string) ([]string, error) {
+	var names []string
+	err := tools.Retry("list nodes", func() error {
+		nodes, err := c.CoreV1().Nodes().List(context.TODO(), metaV1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return apiError(err)
+		}
+		names = names[:0]
+		for _, node := range nodes.Items {
+			if match.MatchString(node.Name) {
+				names = append(names, node.Name)
+			}
+		}
+		return nil
+	})
+	return names, err
+}
+
+// NodeLogs 通过 api server 的 nodes/proxy 访问 kubelet 的 /logs/ 接口, 保存到 destFile;
+// logPath 为 /var/log 下的文件, 为空时使用 params 查询 journal (kubelet NodeLogQuery: query=kubelet)
+func NodeLogs(c *kubernetes.Clientset, node, logPath string, params map[string]string, destFile string, stream tools.Stream) (int64, error) {
+	// 只有一段路径时 AbsPath 保留末尾的 /, kubelet 的日志查询需要 /logs/?query=
+	absPath := fmt.Sprintf("/api/v1/nodes/%v/proxy/logs/%v", node, strings.TrimLeft(logPath, "/"))
+	var size int64
+	err := tools.Retry(fmt.Sprintf("%v node logs %v", node, logPath), func() error {
+		req := c.CoreV1().RESTClient().Get().AbsPath(absPath)
+		for key, value := range params {
+			req = req.Param(key, value)
+		}
+		reader, err := req.Stream(context.TODO())
+		if err != nil {
+			return apiError(err)
+		}
+		defer reader.Close()
+		transfer := tools.NewTransfer(node+":"+absPath, 0)
+		defer transfer.Done()
+		size, err = tools.LimitDownload(transfer.Reader(reader), destFile, stream.WithBucket(tools.HostBucket(node)))
+		return err
+	})
+	return size, err
+}
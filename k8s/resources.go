@@ -0,0 +1,98 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"log-collect/tools"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// DefaultKinds kinds collected by k8s_resources when kinds is empty
+var DefaultKinds = []string{"pods", "deployments", "statefulsets", "configmaps", "services", "endpoints"}
+
+// ResourceLister 通过 discovery 把 kind 及简写(deploy, svc, cm)解析为资源, 使用 dynamic client 列出对象
+type ResourceLister struct {
+	mapper meta.RESTMapper
+	client dynamic.Interface
+}
+
+func NewResourceLister(r *rest.Config, c *kubernetes.Clientset) (*ResourceLister, error) {
+	var groups []*restmapper.APIGroupResources
+	err := tools.Retry("discovery api resources", func() error {
+		var err error
+		groups, err = restmapper.GetAPIGroupResources(c.Discovery())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewShortcutExpander(restmapper.NewDiscoveryRESTMapper(groups), c.Discovery())
+	return &ResourceLister{mapper: mapper, client: client}, nil
+}
+
+// List objects of kind in ns (ignored for cluster scoped kinds), return resource name (plural) and objects
+func (l *ResourceLister) List(kind, ns, selector string) (string, []unstructured.Unstructured, error) {
+	gvr, err := l.mapper.ResourceFor(schema.ParseGroupResource(kind).WithVersion(""))
+	if err != nil {
+		return kind, nil, err
+	}
+	gvk, err := l.mapper.KindFor(gvr)
+	if err != nil {
+		return gvr.Resource, nil, err
+	}
+	mapping, err := l.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return gvr.Resource, nil, err
+	}
+	var resource dynamic.ResourceInterface = l.client.Resource(gvr)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		resource = l.client.Resource(gvr).Namespace(ns)
+	}
+	var list *unstructured.UnstructuredList
+	err = tools.Retry(fmt.Sprintf("%v list %v", ns, gvr.Resource), func() error {
+		var err error
+		list, err = resource.List(context.TODO(), metaV1.ListOptions{LabelSelector: selector})
+		return err
+	})
+	if err != nil {
+		return gvr.Resource, nil, err
+	}
+	return gvr.Resource, list.Items, nil
+}
+
+// CleanObject 去掉 managedFields; Secret 的 data/stringData 替换为 <redacted>, 只保留 key
+func CleanObject(obj *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	if obj.GetKind() != "Secret" {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		data, found, _ := unstructured.NestedMap(obj.Object, field)
+		if !found {
+			continue
+		}
+		for key := range data {
+			data[key] = "<redacted>"
+		}
+		_ = unstructured.SetNestedMap(obj.Object, data, field)
+	}
+	// kubectl apply 保存的原始配置中也有 Secret 的数据
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations["kubectl.kubernetes.io/last-applied-configuration"]; ok {
+		delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+		obj.SetAnnotations(annotations)
+	}
+}
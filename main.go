@@ -9,48 +9,110 @@ import (
 	"log-collect/ssh"
 	"log-collect/tools"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
+	"github.com/juju/ratelimit"
 	"gopkg.in/yaml.v2"
+	coreV1 "k8s.io/api/core/v1"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
 	clientSet  *kubernetes.Clientset
 	kubeConfig *rest.Config
+	summary    = tools.NewReport()
 )
 
 const sysType = runtime.GOOS
 
+var (
+	errDiskSpace      = &tools.NewError{Msg: "disk + logfile must < 85%"}
+	errNoFileInWindow = &tools.NewError{Msg: "no file in time window"}
+	errNoMatch        = &tools.NewError{Msg: "no line matched"}
+)
+
 type Args struct {
-	Mode     *string
-	Name     *string
-	LogDir   *string
-	Debug    *bool
-	Limit    *int
-	HostYaml *string
-	ConfYaml *string
+	Mode      *string
+	Name      *string
+	LogDir    *string
+	Debug     *bool
+	Limit     *int
+	HostLimit *int
+	Parallel  *int
+	Retry     *int
+	RetryWait *time.Duration
+	Resume    *bool
+	Verify    *bool
+	Since     *string
+	Until     *string
+	Grep      *string
+	Context   *int
+	Format    *string
+	SplitSize *string
+	// 加密/解密
+	Passphrase *string
+	Recipient  *string
+	Identity   *string
+	HostYaml   *string
+	ConfYaml   *string
 }
 type Log struct {
-	Type        string `yaml:"type"`
-	NS          string `yaml:"namespace"`
-	Pod         string `yaml:"pod"`
-	Name        string `yaml:"name"`
-	Dir         string `yaml:"dir"`
-	File        string `yaml:"file"`
-	Container   string `yaml:"container"`
-	HostGroup   string `yaml:"hostgroup"`
-	Host        string `yaml:"host"`
-	Num         string `yaml:"num"`
-	HostInfo    []HostInfo
-	podNameList []string
+	Type       string `yaml:"type"`
+	NS         string `yaml:"namespace"`
+	Pod        string `yaml:"pod"`
+	Name       string `yaml:"name"`
+	Dir        string `yaml:"dir"`
+	File       string `yaml:"file"`
+	Container  string `yaml:"container"`
+	HostGroup  string `yaml:"hostgroup"`
+	Host       string `yaml:"host"`
+	Num        string `yaml:"num"`
+	Parallel   int    `yaml:"parallel"`
+	Limit      int    `yaml:"limit"`
+	Since      string `yaml:"since"`
+	Until      string `yaml:"until"`
+	TimeFormat string `yaml:"timeformat"`
+	Grep       string `yaml:"grep"`
+	Context    int    `yaml:"context"`
+	TailLines  int64  `yaml:"tail_lines"`
+	TailBytes  int64  `yaml:"tail_bytes"`
+	// gzip/zstd/none
+	RemoteCompress string `yaml:"remote_compress"`
+	// journald
+	Units    []string `yaml:"units"`
+	Priority string   `yaml:"priority"`
+	Output   string   `yaml:"output"`
+	// k8s/kubectl_logs 同时保存匹配的 pod 的事件
+	Events bool `yaml:"events"`
+	// k8s_resources: 资源类型, 名称(正则)和标签选择器
+	Kinds    []string `yaml:"kinds"`
+	Match    string   `yaml:"match"`
+	Selector string   `yaml:"selector"`
+	// k8s_node: 节点 /var/log 下的文件, 节点使用 match/selector 选择, journal 使用 units
+	Files []string `yaml:"files"`
+	// container: container 为容器名(正则), labels 为 key=value
+	Labels []string `yaml:"labels"`
+	// kubectl_logs
+	SinceTime     string `yaml:"sincetime"`
+	Previous      bool   `yaml:"previous"`
+	Timestamps    bool   `yaml:"timestamps"`
+	LimitBytes    int64  `yaml:"limitbytes"`
+	AllContainers bool   `yaml:"allcontainers"`
+	HostInfo      []HostInfo
+	podNameList   []string
+	groupLimit    int
+	window        tools.TimeWindow
+	stream        tools.Stream
+	redactor      *tools.Redactor
 }
 type HostInfo struct {
 	IP       string `yaml:"ip"`
@@ -63,11 +125,13 @@ type HostGroup struct {
 	Port     int        `yaml:"port"`
 	User     string     `yaml:"user"`
 	Password string     `yaml:"password"`
+	Limit    int        `yaml:"limit"`
 	Host     []HostInfo `yaml:"ips"`
 }
 type Config struct {
 	HostGroups map[string]HostGroup `yaml:"host"`
 	Logs       []Log                `yaml:"logs"`
+	Redact     []tools.RedactRule   `yaml:"redact"`
 	Debug      bool                 `yaml:"debug"`
 }
 
@@ -93,11 +157,15 @@ func (ctx Config) ReadHost(path string) map[string]HostGroup {
 			log.Fatalln(err)
 		}
 	}
+	// 保留 conf.yml 中主机组的配置(limit等), 主机列表以 host.yml 为准
+	groups := ctx.HostGroups
 	ctx.HostGroups = make(map[string]HostGroup)
 	allHost := HostGroup{}
 	for key, value := range *conf {
 		allHost.Host = append(allHost.Host, value...)
-		ctx.HostGroups[key] = HostGroup{Host: value}
+		group := groups[key]
+		group.Host = value
+		ctx.HostGroups[key] = group
 	}
 	ctx.HostGroups["all"] = allHost
 	return ctx.HostGroups
@@ -123,6 +191,18 @@ func (ctx Config) UpdateHosts() {
 	//ctx.HostGroups["all"] = allHost
 
 }
+
+// sshClient Get the pooled connection of host
+func (host HostInfo) sshClient() (*ssh.SSH, error) {
+	return ssh.GetClient(&ssh.SSH{
+		Host:     host.IP,
+		Port:     int64(host.Port),
+		Username: host.User,
+		Password: host.Password,
+		KeyFile:  host.KeyFile,
+	})
+}
+
 func (ctx Config) getLogNameList(name string) Log {
 	for _, logItem := range ctx.Logs {
 		if logItem.Name == name {
@@ -132,7 +212,7 @@ func (ctx Config) getLogNameList(name string) Log {
 	return Log{}
 }
 func (ctx Log) GetLogHost(conf Config) []HostInfo {
-	if ctx.Type == "ssh" {
+	if ctx.Type == "ssh" || ctx.Type == "journald" || ctx.Type == "container" {
 		ctx.HostInfo = append(ctx.HostInfo, conf.HostGroups[ctx.HostGroup].Host...)
 	}
 	return ctx.HostInfo
@@ -166,14 +246,10 @@ func (ctx Log) checkFileLink(dir, pod string, host HostInfo) (error, string) {
 		dirLink := dirList[len(dirList)-1]
 		return nil, tools.Strip(dirLink, "\n")
 	} else if ctx.Type == "ssh" {
-		cli := ssh.SSH{
-			Host:     host.IP,
-			Port:     int64(host.Port),
-			Username: host.User,
-			Password: host.Password,
-			KeyFile:  host.KeyFile,
-		}
-		cli.CreateClient()
+		cli, err := host.sshClient()
+		if err != nil {
+			return err, dir
+		}
 		result, err := cli.RunShell(cmdStr)
 
 		if err != nil {
@@ -210,105 +286,845 @@ func CheckTarCmd(pod, ns, container string) bool {
 	}
 }
 func (ctx Log) K8sFile(arg Args, destDir string) {
-	for _, podName := range ctx.GetAllPod() {
-		var err error
-		newDir := ""
-		if newDir, err = ctx.regToRealDir(podName, HostInfo{}); err != nil {
-			log.Fatalln("[ERROR] ", podName, err)
-		}
-		newFilePathStr := ""
-		if newFilePathStr, err = ctx.regToRealFile(newDir, podName, HostInfo{}); err != nil {
-			log.Fatalln("[ERROR] ", newDir, ctx.File, err)
-		}
-		newFilePathList := strings.Split(newFilePathStr, "\n")
-		for _, newFilePath := range newFilePathList {
-			err, logFilePath := ctx.checkFileLink(newFilePath, podName, HostInfo{})
-			if err == nil {
-				//srcDir := strings.Split(newFilePath, "/")
-				paths, _ := filepath.Split(newFilePath)
-				logFilePath = paths + "/" + logFilePath
+	start := time.Now()
+	podNameList := ctx.GetAllPod()
+	if len(podNameList) == 0 {
+		ctx.record(ctx.Pod, ctx.filePattern(), start, 0, "", &tools.NewError{Msg: "not match pod"})
+		return
+	}
+	tools.Parallel(ctx.getParallel(arg), len(podNameList), func(index int) {
+		ctx.k8sPodFile(arg, destDir, podNameList[index])
+	})
+}
+
+func (ctx Log) k8sPodFile(arg Args, destDir, podName string) {
+	start := time.Now()
+	newFilePathList, err := ctx.matchFiles(podName, HostInfo{})
+	if err != nil {
+		ctx.record(podName, ctx.filePattern(), start, 0, "", err)
+		return
+	}
+	if len(newFilePathList) == 0 {
+		ctx.record(podName, ctx.filePattern(), start, 0, tools.StatusSkipped, errNoFileInWindow)
+		return
+	}
+	isTar := !ctx.remoteFilter() && CheckTarCmd(podName, ctx.NS, ctx.Container)
+	codec := ctx.remoteCodec(podName, HostInfo{})
+	for _, newFilePath := range newFilePathList {
+		start = time.Now()
+		err, logFilePath := ctx.checkFileLink(newFilePath, podName, HostInfo{})
+		if err == nil && !path.IsAbs(logFilePath) {
+			//srcDir := strings.Split(newFilePath, "/")
+			paths, _ := filepath.Split(newFilePath)
+			logFilePath = path.Join(paths, logFilePath)
+		}
+		total, ok, err := ctx.checkSpace(arg, logFilePath, podName, HostInfo{})
+		if err != nil {
+			ctx.record(podName, logFilePath, start, 0, "", err)
+			continue
+		}
+		if !ok {
+			ctx.record(podName, logFilePath, start, 0, tools.StatusSkipped, errDiskSpace)
+			continue
+		}
+		if ctx.remoteFilter() {
+			saveFile := fmt.Sprintf("%v/%v/%v", destDir, podName, filepath.Base(logFilePath))
+			cmd := ctx.remoteCommand(logFilePath)
+			log.Printf("[INFO] Run %v %v - %v", podName, cmd, saveFile)
+			size, err := k8s.DownloadCommand(kubeConfig, clientSet, podName, ctx.NS, ctx.Container,
+				cmd, saveFile, ctx.sourceStream(podName, logFilePath))
+			size, status, err := ctx.commandStatus(saveFile, size, err)
+			ctx.record(podName, logFilePath, start, size, status, err)
+			continue
+		}
+		size, err := k8s.CopyFromPod(
+			kubeConfig, clientSet, podName, ctx.NS, logFilePath, destDir, ctx.Container, isTar, total, codec, ctx.fileStream(podName, logFilePath),
+		)
+		ctx.record(podName, logFilePath, start, size, "", err)
+	}
+}
+
+// KubectlLogs 拉取匹配的 pod 的容器日志, 同 kubectl logs
+func (ctx Log) KubectlLogs(arg Args, destDir string) {
+	start := time.Now()
+	opts, err := ctx.podLogOptions()
+	if err != nil {
+		ctx.record(ctx.Pod, "logs", start, 0, "", err)
+		return
+	}
+	podNameList := ctx.GetAllPod()
+	if len(podNameList) == 0 {
+		ctx.record(ctx.Pod, "logs", start, 0, "", &tools.NewError{Msg: "not match pod"})
+		return
+	}
+	tools.Parallel(ctx.getParallel(arg), len(podNameList), func(index int) {
+		ctx.podLogs(destDir, podNameList[index], *opts)
+	})
+}
+
+func (ctx Log) podLogs(destDir, podName string, opts coreV1.PodLogOptions) {
+	start := time.Now()
+	containers := []string{ctx.Container}
+	var err error
+	if ctx.AllContainers {
+		containers, err = k8s.PodContainers(clientSet, podName, ctx.NS)
+	} else if ctx.Container == "" {
+		// 多个容器的 pod 必须指定容器
+		containers[0], err = k8s.DefaultContainer(clientSet, podName, ctx.NS)
+	}
+	if err != nil {
+		ctx.record(podName, "logs", start, 0, "", err)
+		return
+	}
+	for _, container := range containers {
+		start = time.Now()
+		opts.Container = container
+		name := container
+		if !ctx.AllContainers && ctx.Container == "" {
+			name = "pod"
+		}
+		if opts.Previous {
+			name += "-previous"
+		}
+		destFile := fmt.Sprintf("%s/%s-%s.log", destDir, podName, name)
+		log.Printf("[INFO] Download %s logs %s to %s", podName, container, destFile)
+		size, err := k8s.PodLogs(clientSet, podName, ctx.NS, &opts, destFile, ctx.fileStream(podName, "logs/"+name))
+		status := ""
+		if err != nil && opts.Previous && k8s.NoPreviousLogs(err) {
+			// 容器没有重启过, 没有上一次的日志
+			status = tools.StatusSkipped
+			_ = os.Remove(destFile)
+		}
+		ctx.record(podName, "logs/"+name, start, size, status, err)
+	}
+}
+
+// podLogOptions since: -since 或日志配置的 since, sincetime: RFC3339 优先; num: 最后多少行
+func (ctx Log) podLogOptions() (*coreV1.PodLogOptions, error) {
+	opts := &coreV1.PodLogOptions{
+		Previous:   ctx.Previous,
+		Timestamps: ctx.Timestamps,
+	}
+	if ctx.Num != "" {
+		num, err := strconv.ParseInt(ctx.Num, 10, 64)
+		if err != nil {
+			return nil, &tools.NewError{Msg: "invalid num: " + ctx.Num}
+		}
+		opts.TailLines = &num
+	}
+	if ctx.LimitBytes > 0 {
+		limitBytes := ctx.LimitBytes
+		opts.LimitBytes = &limitBytes
+	}
+	if ctx.SinceTime != "" {
+		sinceTime, err := time.Parse(time.RFC3339, ctx.SinceTime)
+		if err != nil {
+			return nil, &tools.NewError{Msg: "invalid sincetime: " + ctx.SinceTime}
+		}
+		opts.SinceTime = &metaV1.Time{Time: sinceTime}
+	} else if !ctx.window.Since.IsZero() {
+		opts.SinceTime = &metaV1.Time{Time: ctx.window.Since}
+	}
+	return opts, nil
+}
+
+// matchFiles 匹配 dir/file 的所有路径, 设置了时间范围时只保留修改时间与时间范围重叠的文件
+func (ctx Log) matchFiles(pod string, host HostInfo) ([]string, error) {
+	if ctx.File == "" && !ctx.window.IsZero() {
+		// 按时间过滤目录中的文件
+		ctx.File = "*"
+	}
+	newDir, err := ctx.regToRealDir(pod, host)
+	if err != nil {
+		return nil, err
+	}
+	result, err := ctx.regToRealFile(newDir, pod, host)
+	if err != nil {
+		return nil, err
+	}
+	files := strings.Split(result, "\n")
+	if ctx.window.IsZero() {
+		return files, nil
+	}
+	mtimes, err := ctx.fileMtimes(files, pod, host)
+	if err != nil {
+		log.Printf("[WARN] get mtime failed, time window is ignored: %v", err)
+		return files, nil
+	}
+	matched := ctx.window.FilterFiles(files, mtimes)
+	log.Printf("[INFO] %v %v%v: %v/%v files in time window", ctx.Name, pod, host.IP, len(matched), len(files))
+	return matched, nil
+}
+
+// fileMtimes 远程文件的修改时间
+func (ctx Log) fileMtimes(files []string, pod string, host HostInfo) ([]time.Time, error) {
+	mtimes := make([]time.Time, len(files))
+	if ctx.Type == "k8s" {
+		var quoted []string
+		for _, file := range files {
+			quoted = append(quoted, tools.ShellQuote(file))
+		}
+		cmdStr := "stat -L -c '%Y %n' " + strings.Join(quoted, " ")
+		result, err := k8s.Exec(kubeConfig, clientSet, pod, ctx.NS, cmdStr, ctx.Container)
+		if err != nil {
+			return nil, &tools.NewError{Msg: result + err.Error()}
+		}
+		found := make(map[string]time.Time)
+		for _, line := range strings.Split(result, "\n") {
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) != 2 {
+				continue
 			}
-			if ctx.checkSpace(arg, logFilePath, podName, HostInfo{}) {
-				isTar := CheckTarCmd(podName, ctx.NS, ctx.Container)
-				err := k8s.CopyFromPod(
-					kubeConfig, clientSet, podName, ctx.NS, logFilePath, destDir, ctx.Container, isTar,
-				)
-				if err != nil {
-					log.Printf("ERROR: %s", err)
-				}
-			} else {
-				log.Println("ERROR: disk + logfile must < 85%")
+			if seconds, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				found[fields[1]] = time.Unix(seconds, 0)
 			}
 		}
+		for index, file := range files {
+			mtimes[index] = found[file]
+		}
+		return mtimes, nil
+	}
+	cli, err := host.sshClient()
+	if err != nil {
+		return nil, err
 	}
+	for index, file := range files {
+		if info, err := cli.Stat(file); err == nil {
+			mtimes[index] = info.ModTime()
+		}
+	}
+	return mtimes, nil
 }
 
-func (ctx Log) SSHFile(arg Args, destDir string) {
-	if len(ctx.HostInfo) == 0 {
-		log.Fatalln("[ERROR] not match host")
+// timeWindow -since/-until 优先, 否则使用日志配置的 since/until
+func (ctx Log) timeWindow(arg Args) (tools.TimeWindow, error) {
+	since, until := ctx.Since, ctx.Until
+	if *arg.Since != "" {
+		since = *arg.Since
 	}
-	for _, host := range ctx.HostInfo {
-		var err error
-		newDir := ""
-		if newDir, err = ctx.regToRealDir("", host); err != nil {
-			log.Fatalln("[ERROR] ", err)
+	if *arg.Until != "" {
+		until = *arg.Until
+	}
+	return tools.NewTimeWindow(since, until)
+}
+
+// limitBucket 日志/主机组配置的限速, 不能超过 -limit
+func limitBucket(arg Args, key string, limit int) *ratelimit.Bucket {
+	if limit <= 0 {
+		return nil
+	}
+	if *arg.Limit > 0 && limit > *arg.Limit {
+		limit = *arg.Limit
+	}
+	return tools.SharedBucket(key, limit)
+}
+
+// remoteFilter 需要在远程执行命令读取文件: grep 或 tail
+func (ctx Log) remoteFilter() bool {
+	return ctx.Grep != "" || ctx.tailOption() != ""
+}
+
+// tailOption tail 的参数, tail_lines 优先
+func (ctx Log) tailOption() string {
+	if ctx.TailLines > 0 {
+		return fmt.Sprintf("-n %d", ctx.TailLines)
+	}
+	if ctx.TailBytes > 0 {
+		return fmt.Sprintf("-c %d", ctx.TailBytes)
+	}
+	return ""
+}
+
+// truncated 文件只拉取了最后一部分
+func (ctx Log) truncated() bool {
+	return (ctx.Type == "ssh" || ctx.Type == "k8s") && ctx.tailOption() != ""
+}
+
+// remoteCommand 远程读取文件的命令: tail 截取每个文件的末尾, 再 grep 过滤匹配的行;
+// 目录中的文件 tail 后合并输出, 只 grep 时递归查找
+func (ctx Log) remoteCommand(file string) string {
+	quoted := tools.ShellQuote(file)
+	grep := ""
+	if ctx.Grep != "" {
+		grep = "grep -E"
+		if ctx.Context > 0 {
+			grep += fmt.Sprintf(" -C %d", ctx.Context)
 		}
+		grep += " -e " + tools.ShellQuote(ctx.Grep)
+	}
+	tail := ctx.tailOption()
+	if tail == "" {
+		return grep + " -r -- " + quoted
+	}
+	cmd := fmt.Sprintf("if [ -d %[1]v ]; then find %[1]v -type f -exec tail %[2]v {} +; else tail %[2]v -- %[1]v; fi", quoted, tail)
+	if grep != "" {
+		cmd += " | " + grep
+	}
+	return cmd
+}
 
-		newFilePath := ""
-		if newFilePath, err = ctx.regToRealFile(newDir, "", host); err != nil {
-			log.Fatalln("[ERROR] ", newDir, newFilePath, ctx.Dir, ctx.File, err)
+// remoteCodec 远程可用的压缩工具, 没有该工具时降级: zstd -> gzip -> 不压缩; grep/tail 的结果不压缩
+func (ctx Log) remoteCodec(pod string, host HostInfo) *tools.Codec {
+	if ctx.remoteFilter() {
+		return nil
+	}
+	codecs, _ := tools.Fallbacks(ctx.RemoteCompress)
+	for _, codec := range codecs {
+		cmdStr := "command -v " + codec.Name
+		var err error
+		if ctx.Type == "k8s" {
+			_, err = k8s.Exec(kubeConfig, clientSet, pod, ctx.NS, cmdStr, ctx.Container)
+		} else {
+			var cli *ssh.SSH
+			if cli, err = host.sshClient(); err == nil {
+				_, err = cli.RunShell(cmdStr)
+			}
+		}
+		if err == nil {
+			return codec
 		}
+		log.Printf("[WARN] %v%v %v not found, remote_compress fallback", pod, host.IP, codec.Name)
+	}
+	return nil
+}
+
+// fileStream 单个文件的数据流, 配置了 redact 时脱敏并记录替换次数
+func (ctx Log) fileStream(target, file string) tools.Stream {
+	return ctx.redactStream(ctx.stream, target, file)
+}
+
+// objectStream k8s 对象(yaml/json)的数据流, 只限速和脱敏, 不按时间过滤行
+func (ctx Log) objectStream(target, file string) tools.Stream {
+	return ctx.redactStream(tools.Stream{Buckets: ctx.stream.Buckets}, target, file)
+}
+
+func (ctx Log) redactStream(stream tools.Stream, target, file string) tools.Stream {
+	if ctx.redactor == nil {
+		return stream
+	}
+	base := stream
+	stream = stream.WithFilter(ctx.redactor.Filter(ctx.redactKey(target, file)))
+	// 目录中的每个文件分别记录替换次数
+	stream.PerFile = func(file string) tools.Stream {
+		return ctx.redactStream(base, target, file)
+	}
+	return stream
+}
+
+func (ctx Log) redactKey(target, file string) string {
+	return ctx.Name + "/" + target + ":" + file
+}
+
+// sourceStream 在结果前写入来源主机/pod, 文件, 以及 grep/tail 条件
+func (ctx Log) sourceStream(target, file string) tools.Stream {
+	header := fmt.Sprintf("# source: %v:%v", target, file)
+	if ctx.Grep != "" {
+		header += " grep: " + ctx.Grep
+	}
+	if ctx.TailLines > 0 {
+		header += fmt.Sprintf(" tail_lines: %d", ctx.TailLines)
+	} else if ctx.TailBytes > 0 {
+		header += fmt.Sprintf(" tail_bytes: %d", ctx.TailBytes)
+	}
+	return ctx.fileStream(target, file).WithFilter(tools.Header(header + "\n"))
+}
+
+// commandStatus grep 返回码 1 表示没有匹配的行, 删除只有来源信息的文件
+func (ctx Log) commandStatus(saveFile string, size int64, err error) (int64, string, error) {
+	if code, ok := tools.ExitStatus(err); ok && code == 1 && ctx.Grep != "" {
+		_ = os.Remove(saveFile)
+		return 0, tools.StatusSkipped, errNoMatch
+	}
+	return size, "", err
+}
+
+// getParallel 单个日志配置的并发数优先, 否则使用 -parallel
+func (ctx Log) getParallel(arg Args) int {
+	if ctx.Parallel > 0 {
+		return ctx.Parallel
+	}
+	return *arg.Parallel
+}
+
+func (ctx Log) filePattern() string {
+	return strings.TrimRight(ctx.Dir, "/") + "/" + ctx.File
+}
+
+// record 记录单个文件的拉取结果, status 为空时根据 err 判断成功或失败
+func (ctx Log) record(target, file string, start time.Time, size int64, status string, err error) {
+	if err != nil {
+		log.Printf("[ERROR] %v %v %v: %v", ctx.Name, target, file, err)
+	}
+	summary.Add(tools.Result{
+		Log:           ctx.Name,
+		Target:        target,
+		File:          file,
+		Status:        status,
+		Bytes:         size,
+		Truncated:     err == nil && status == "" && ctx.truncated(),
+		Redacted:      ctx.redactor.Counts(ctx.redactKey(target, file)),
+		RedactedFiles: ctx.redactor.FileCounts(ctx.redactKey(target, file)),
+	}, start, err)
+}
 
+func (ctx Log) SSHFile(arg Args, destDir string) {
+	if len(ctx.HostInfo) == 0 {
+		ctx.record(ctx.HostGroup, ctx.filePattern(), time.Now(), 0, "", &tools.NewError{Msg: "not match host"})
+		return
+	}
+	ctx.stream = ctx.stream.WithBucket(limitBucket(arg, "group/"+ctx.HostGroup, ctx.groupLimit))
+	tools.Parallel(ctx.getParallel(arg), len(ctx.HostInfo), func(index int) {
+		ctx.sshHostFile(arg, destDir, ctx.HostInfo[index])
+	})
+}
+
+func (ctx Log) sshHostFile(arg Args, destDir string, host HostInfo) {
+	start := time.Now()
+	newFilePathList, err := ctx.matchFiles("", host)
+	if err != nil {
+		ctx.record(host.IP, ctx.filePattern(), start, 0, "", err)
+		return
+	}
+	if len(newFilePathList) == 0 {
+		ctx.record(host.IP, ctx.filePattern(), start, 0, tools.StatusSkipped, errNoFileInWindow)
+		return
+	}
+	cli, err := host.sshClient()
+	if err != nil {
+		ctx.record(host.IP, ctx.filePattern(), start, 0, "", err)
+		return
+	}
+	codec := ctx.remoteCodec("", host)
+	for _, newFilePath := range newFilePathList {
+		start = time.Now()
 		//logPath := newDir + newFilePath
 		_, logFilePath := ctx.checkFileLink(newFilePath, "", host)
 
-		if ctx.checkSpace(arg, logFilePath, "", host) {
-			cli := ssh.SSH{
-				Host:     host.IP,
-				Port:     int64(host.Port),
-				Username: host.User,
-				Password: host.Password,
-				KeyFile:  host.KeyFile,
+		_, ok, err := ctx.checkSpace(arg, logFilePath, "", host)
+		if err != nil {
+			ctx.record(host.IP, logFilePath, start, 0, "", err)
+			continue
+		}
+		if !ok {
+			ctx.record(host.IP, logFilePath, start, 0, tools.StatusSkipped, errDiskSpace)
+			continue
+		}
+		saveFile := fmt.Sprintf("%v/%v-%v", destDir, host.IP, filepath.Base(logFilePath))
+		if ctx.remoteFilter() {
+			cmd := ctx.remoteCommand(logFilePath)
+			log.Printf("[INFO] Run %v %v - %v", host.IP, cmd, saveFile)
+			size, err := cli.DownloadCommand(cmd, saveFile, ctx.sourceStream(host.IP, logFilePath))
+			size, status, err := ctx.commandStatus(saveFile, size, err)
+			ctx.record(host.IP, logFilePath, start, size, status, err)
+			continue
+		}
+		log.Printf("[INFO] Download %v - %v", logFilePath, saveFile)
+		if codec != nil {
+			_, size, err := cli.DownloadCompressed(logFilePath, saveFile, codec, ctx.fileStream(host.IP, logFilePath))
+			ctx.record(host.IP, logFilePath, start, size, "", err)
+			continue
+		}
+		size, err := cli.Download(logFilePath, saveFile, ctx.fileStream(host.IP, logFilePath))
+		ctx.record(host.IP, logFilePath, start, size, "", err)
+	}
+}
+
+// K8sEvents 保存命名空间的事件, byPod: 只保存匹配的 pod 的事件
+func (ctx Log) K8sEvents(destDir string, byPod bool) {
+	start := time.Now()
+	var pods []string
+	if byPod {
+		// 不为 nil, 没有匹配的 pod 时不保存任何事件
+		pods = append([]string{}, ctx.GetAllPod()...)
+	}
+	events, err := k8s.Events(clientSet, ctx.NS, pods, ctx.window)
+	if err != nil {
+		ctx.record(ctx.NS, "events", start, 0, "", err)
+		return
+	}
+	ext := ".yaml"
+	if ctx.Output == "json" {
+		ext = ".json"
+	}
+	destFile := filepath.Join(destDir, "events"+ext)
+	log.Printf("[INFO] Save %v events of %v to %v", len(events.Items), ctx.NS, destFile)
+	size, err := k8s.WriteObject(events, ctx.Output, destFile, ctx.objectStream(ctx.NS, "events"))
+	ctx.record(ctx.NS, "events", start, size, "", err)
+}
+
+// K8sResources 保存资源的 yaml/json 到 <kind>/<name>.yaml, 去掉 managedFields, Secret 的数据不保存
+func (ctx Log) K8sResources(destDir string) {
+	start := time.Now()
+	matchReg, err := regexp.Compile(ctx.Match)
+	if err != nil {
+		ctx.record(ctx.NS, "resources", start, 0, "", err)
+		return
+	}
+	lister, err := k8s.NewResourceLister(kubeConfig, clientSet)
+	if err != nil {
+		ctx.record(ctx.NS, "resources", start, 0, "", err)
+		return
+	}
+	ext := ".yaml"
+	if ctx.Output == "json" {
+		ext = ".json"
+	}
+	kinds := ctx.Kinds
+	if len(kinds) == 0 {
+		kinds = k8s.DefaultKinds
+	}
+	for _, kind := range kinds {
+		start = time.Now()
+		resource, items, err := lister.List(kind, ctx.NS, ctx.Selector)
+		if err != nil {
+			ctx.record(ctx.NS, resource, start, 0, "", err)
+			continue
+		}
+		count := 0
+		for _, item := range items {
+			if !matchReg.MatchString(item.GetName()) {
+				continue
 			}
-			cli.CreateClient()
-			saveFile := fmt.Sprintf("%v/%v-%v", destDir, host.IP, filepath.Base(logFilePath))
-			log.Printf("[INFO] Download %v - %v", logFilePath, saveFile)
-			err := cli.Download(logFilePath, saveFile)
-			if err != nil {
-				log.Printf("[ERROR] download failed %v\n", err)
+			start = time.Now()
+			count++
+			k8s.CleanObject(&item)
+			file := resource + "/" + item.GetName()
+			destFile := filepath.Join(destDir, resource, item.GetName()+ext)
+			if _, err := tools.Mkdir(filepath.Dir(destFile)); err != nil {
+				ctx.record(ctx.NS, file, start, 0, "", err)
+				continue
 			}
+			size, err := k8s.WriteObject(item.Object, ctx.Output, destFile, ctx.objectStream(ctx.NS, file))
+			ctx.record(ctx.NS, file, start, size, "", err)
+		}
+		log.Printf("[INFO] Save %v %v of %v to %v", count, resource, ctx.NS, destDir)
+	}
+}
 
-		} else {
-			log.Println("[ERROR] disk + logfile must < 85%")
+// K8sNode 不需要 ssh, 通过 api server 的 nodes/proxy 拉取节点的日志文件和 journal, 保存到每个节点的目录中
+func (ctx Log) K8sNode(arg Args, destDir string) {
+	start := time.Now()
+	matchReg, err := regexp.Compile(ctx.Match)
+	if err != nil {
+		ctx.record("-", "nodes", start, 0, "", err)
+		return
+	}
+	nodes, err := k8s.Nodes(clientSet, matchReg, ctx.Selector)
+	if err != nil {
+		ctx.record("-", "nodes", start, 0, "", err)
+		return
+	}
+	if len(nodes) == 0 {
+		ctx.record(ctx.Match+ctx.Selector, "nodes", start, 0, "", &tools.NewError{Msg: "not match node"})
+		return
+	}
+	tools.Parallel(ctx.getParallel(arg), len(nodes), func(index int) {
+		ctx.nodeLogs(destDir, nodes[index])
+	})
+}
+
+func (ctx Log) nodeLogs(destDir, node string) {
+	nodeDir := filepath.Join(destDir, node)
+	units := ctx.Units
+	if len(units) == 0 && len(ctx.Files) == 0 {
+		units = []string{"kubelet"}
+	}
+	for _, file := range ctx.Files {
+		start := time.Now()
+		file = strings.TrimLeft(path.Clean("/"+file), "/")
+		destFile := filepath.Join(nodeDir, filepath.FromSlash(file))
+		if _, err := tools.Mkdir(filepath.Dir(destFile)); err != nil {
+			ctx.record(node, file, start, 0, "", err)
+			continue
 		}
+		log.Printf("[INFO] Download %v /var/log/%v - %v", node, file, destFile)
+		size, err := k8s.NodeLogs(clientSet, node, file, nil, destFile, ctx.fileStream(node, file))
+		ctx.record(node, file, start, size, "", err)
+	}
+	for _, unit := range units {
+		start := time.Now()
+		destFile := filepath.Join(nodeDir, unit+".log")
+		if _, err := tools.Mkdir(nodeDir); err != nil {
+			ctx.record(node, "journal/"+unit, start, 0, "", err)
+			continue
+		}
+		log.Printf("[INFO] Download %v journal %v - %v", node, unit, destFile)
+		size, err := k8s.NodeLogs(clientSet, node, "", ctx.nodeLogQuery(unit), destFile, ctx.fileStream(node, "journal/"+unit))
+		ctx.record(node, "journal/"+unit, start, size, "", err)
 	}
 }
 
+// nodeLogQuery kubelet 日志查询(NodeLogQuery)的参数
+func (ctx Log) nodeLogQuery(unit string) map[string]string {
+	params := map[string]string{"query": unit}
+	if !ctx.window.Since.IsZero() {
+		params["sinceTime"] = ctx.window.Since.UTC().Format(time.RFC3339)
+	}
+	if !ctx.window.Until.IsZero() {
+		params["untilTime"] = ctx.window.Until.UTC().Format(time.RFC3339)
+	}
+	if ctx.Num != "" {
+		params["tailLines"] = ctx.Num
+	}
+	if ctx.Grep != "" {
+		params["pattern"] = ctx.Grep
+	}
+	return params
+}
+
+// Journald 通过 ssh 执行 journalctl 拉取 systemd 日志, 保存到每个主机的目录中
+func (ctx Log) Journald(arg Args, destDir string) {
+	if len(ctx.HostInfo) == 0 {
+		ctx.record(ctx.HostGroup, "journal", time.Now(), 0, "", &tools.NewError{Msg: "not match host"})
+		return
+	}
+	ctx.stream = ctx.stream.WithBucket(limitBucket(arg, "group/"+ctx.HostGroup, ctx.groupLimit))
+	tools.Parallel(ctx.getParallel(arg), len(ctx.HostInfo), func(index int) {
+		ctx.hostJournal(destDir, ctx.HostInfo[index])
+	})
+}
+
+func (ctx Log) hostJournal(destDir string, host HostInfo) {
+	start := time.Now()
+	cli, err := host.sshClient()
+	if err != nil {
+		ctx.record(host.IP, "journal", start, 0, "", err)
+		return
+	}
+	hostDir := filepath.Join(destDir, host.IP)
+	if _, err := tools.Mkdir(hostDir); err != nil {
+		ctx.record(host.IP, "journal", start, 0, "", err)
+		return
+	}
+	ext := ".log"
+	if strings.HasPrefix(ctx.Output, "json") {
+		ext = ".json"
+	}
+	units := ctx.Units
+	if len(units) == 0 {
+		units = []string{""}
+	}
+	for _, unit := range units {
+		start = time.Now()
+		name := "journal"
+		if unit != "" {
+			name = unit
+		}
+		saveFile := filepath.Join(hostDir, name+ext)
+		cmd := ctx.journalCommand(unit)
+		log.Printf("[INFO] Run %v %v - %v", host.IP, cmd, saveFile)
+		size, err := cli.DownloadCommand(cmd, saveFile, ctx.fileStream(host.IP, "journal/"+name))
+		ctx.record(host.IP, "journal/"+name, start, size, "", err)
+	}
+}
+
+// journalCommand journalctl 命令, 时间范围使用 unix 时间戳, 不受远程主机时区影响
+func (ctx Log) journalCommand(unit string) string {
+	output := ctx.Output
+	if output == "" {
+		output = "short-iso"
+	}
+	args := []string{"journalctl", "--no-pager", "-o", tools.ShellQuote(output)}
+	if unit != "" {
+		args = append(args, "-u", tools.ShellQuote(unit))
+	}
+	if ctx.Priority != "" {
+		args = append(args, "-p", tools.ShellQuote(ctx.Priority))
+	}
+	if !ctx.window.Since.IsZero() {
+		args = append(args, fmt.Sprintf("--since @%d", ctx.window.Since.Unix()))
+	}
+	if !ctx.window.Until.IsZero() {
+		args = append(args, fmt.Sprintf("--until @%d", ctx.window.Until.Unix()))
+	}
+	if ctx.Num != "" {
+		args = append(args, "-n", tools.ShellQuote(ctx.Num))
+	}
+	return strings.Join(args, " ")
+}
+
+// ContainerLogs 通过 ssh 使用 docker/crictl 拉取主机上容器的日志, 保存到每个主机的目录中
+func (ctx Log) ContainerLogs(arg Args, destDir string) {
+	if len(ctx.HostInfo) == 0 {
+		ctx.record(ctx.HostGroup, "container", time.Now(), 0, "", &tools.NewError{Msg: "not match host"})
+		return
+	}
+	nameReg, err := regexp.Compile("^" + ctx.Container)
+	if err != nil {
+		ctx.record(ctx.HostGroup, "container", time.Now(), 0, "", err)
+		return
+	}
+	ctx.stream = ctx.stream.WithBucket(limitBucket(arg, "group/"+ctx.HostGroup, ctx.groupLimit))
+	tools.Parallel(ctx.getParallel(arg), len(ctx.HostInfo), func(index int) {
+		ctx.hostContainerLogs(destDir, ctx.HostInfo[index], nameReg)
+	})
+}
+
+func (ctx Log) hostContainerLogs(destDir string, host HostInfo, nameReg *regexp.Regexp) {
+	start := time.Now()
+	cli, err := host.sshClient()
+	if err != nil {
+		ctx.record(host.IP, "container", start, 0, "", err)
+		return
+	}
+	containerRuntime, err := cli.ContainerRuntime()
+	if err != nil {
+		ctx.record(host.IP, "container", start, 0, "", err)
+		return
+	}
+	containers, err := cli.ListContainers(containerRuntime, ctx.Labels, ctx.AllContainers)
+	if err != nil {
+		ctx.record(host.IP, "container", start, 0, "", err)
+		return
+	}
+	var matched []ssh.Container
+	names := make(map[string]int)
+	for _, container := range containers {
+		if nameReg.MatchString(container.Name) {
+			matched = append(matched, container)
+			names[containerName(container)]++
+		}
+	}
+	if len(matched) == 0 {
+		ctx.record(host.IP, "container", start, 0, "", &tools.NewError{Msg: "not match container"})
+		return
+	}
+	if !ctx.window.Until.IsZero() && containerRuntime == ssh.RuntimeCrictl {
+		log.Printf("[WARN] %v crictl logs does not support until, ignored", host.IP)
+	}
+	hostDir := filepath.Join(destDir, host.IP)
+	if _, err := tools.Mkdir(hostDir); err != nil {
+		ctx.record(host.IP, "container", start, 0, "", err)
+		return
+	}
+	for _, container := range matched {
+		start = time.Now()
+		name := containerName(container)
+		if names[name] > 1 {
+			// 同名的容器(重启等)加上ID区分
+			id := container.ID
+			if len(id) > 12 {
+				id = id[:12]
+			}
+			name += "-" + id
+		}
+		saveFile := filepath.Join(hostDir, name+".log")
+		cmd := ssh.ContainerLogsCommand(containerRuntime, container.ID, ctx.window.Since, ctx.window.Until, ctx.Num, ctx.Timestamps)
+		log.Printf("[INFO] Run %v %v - %v", host.IP, cmd, saveFile)
+		size, err := cli.DownloadCommand(cmd, saveFile, ctx.fileStream(host.IP, "container/"+name))
+		ctx.record(host.IP, "container/"+name, start, size, "", err)
+	}
+}
+
+// containerName 保存的文件名, crictl 的容器加上 pod 名
+func containerName(container ssh.Container) string {
+	if container.Pod != "" {
+		return container.Pod + "_" + container.Name
+	}
+	return container.Name
+}
+
 func (ctx Log) fetchLogFile(arg Args) {
 	// ll -n sso cp mariadb-sso-test-ss-0:/workspace/agent  ./agent
 	destDir := fmt.Sprintf("%v/%v", *arg.LogDir, ctx.Name)
 	if _, err := tools.Mkdir(destDir); err != nil {
 		log.Fatalln(err)
 	}
+	ctx.stream = ctx.stream.WithBucket(limitBucket(arg, "log/"+ctx.Name, ctx.Limit))
+	start := time.Now()
+	var err error
+	if ctx.window, err = ctx.timeWindow(arg); err != nil {
+		ctx.record("-", ctx.filePattern(), start, 0, "", err)
+		return
+	}
+	if ctx.TimeFormat != "" && !ctx.window.IsZero() {
+		filter, err := tools.TimeFilter(ctx.window, ctx.TimeFormat)
+		if err != nil {
+			ctx.record("-", ctx.filePattern(), start, 0, "", err)
+			return
+		}
+		ctx.stream = ctx.stream.WithFilter(filter)
+	}
+	if _, err := tools.Fallbacks(ctx.RemoteCompress); err != nil {
+		ctx.record("-", ctx.filePattern(), start, 0, "", err)
+		return
+	}
+	if *arg.Grep != "" {
+		ctx.Grep = *arg.Grep
+	}
+	if *arg.Context > 0 {
+		ctx.Context = *arg.Context
+	}
+	if ctx.Type == "kubectl_logs" {
+		if ctx.Grep != "" {
+			log.Printf("[WARN] %v grep is not supported by kubectl_logs, ignored", ctx.Name)
+		}
+		if ctx.TailLines > 0 || ctx.TailBytes > 0 {
+			log.Printf("[WARN] %v tail_lines/tail_bytes is not supported by kubectl_logs, use num", ctx.Name)
+		}
+	}
 	if ctx.Type == "k8s" {
 		initK8sClient()
 		ctx.K8sFile(arg, destDir)
 	} else if ctx.Type == "ssh" {
 		ctx.SSHFile(arg, destDir)
 	} else if ctx.Type == "kubectl_logs" {
-		err := tools.KubectlLogs(ctx.NS, ctx.Pod, ctx.Container, ctx.Num, destDir)
-		if err != nil {
-			log.Println("[ERROR] " + err.Error())
+		initK8sClient()
+		ctx.KubectlLogs(arg, destDir)
+	} else if ctx.Type == "k8s_events" {
+		initK8sClient()
+		ctx.K8sEvents(destDir, ctx.Pod != "")
+	} else if ctx.Type == "k8s_resources" {
+		initK8sClient()
+		ctx.K8sResources(destDir)
+	} else if ctx.Type == "k8s_node" {
+		initK8sClient()
+		ctx.K8sNode(arg, destDir)
+	} else if ctx.Type == "journald" {
+		ctx.Journald(arg, destDir)
+	} else if ctx.Type == "container" {
+		ctx.ContainerLogs(arg, destDir)
+	} else {
+		ctx.record("-", "-", start, 0, "", &tools.NewError{Msg: "no support " + ctx.Type})
+	}
+	if ctx.Events && (ctx.Type == "k8s" || ctx.Type == "kubectl_logs") {
+		ctx.K8sEvents(destDir, true)
+	}
+	if tools.Resume {
+		// 保留未完成的文件, 下次 -resume 继续下载
+		if parts := tools.PartFiles(destDir); len(parts) > 0 {
+			log.Printf("[WARN] %v unfinished downloads in %v, run again with -resume to continue", len(parts), destDir)
 			return
 		}
-	} else {
-		log.Println("[ERROR] no support " + ctx.Type)
 	}
-	err := tools.Compress([]string{destDir}, destDir+".tar.gz", true)
+	// 本日志的拉取结果(是否截断等)一起打包
+	if err := summary.LogReport(ctx.Name).WriteJSON(filepath.Join(destDir, "summary.json")); err != nil {
+		log.Println("[ERROR] write summary failed:", err)
+	}
+	start = time.Now()
+	if *arg.Format == tools.FormatDir {
+		// 保留目录, 方便直接查看
+		if err := tools.WriteManifest(destDir); err != nil {
+			ctx.record("local", destDir, start, 0, "", err)
+			return
+		}
+		log.Printf("[INFO] logfile path: %v", destDir)
+		return
+	}
+	ext, _ := tools.ArchiveExt(*arg.Format)
+	if tools.Encrypting() {
+		ext += tools.EncryptExt
+	}
+	err = tools.Compress([]string{destDir}, destDir+ext, true)
 	if err != nil {
-		log.Fatalln("[ERROR] ", err)
+		ctx.record("local", destDir+ext, start, 0, "", err)
+		return
+	}
+	if tools.SplitSize > 0 {
+		log.Printf("[INFO] logfile path: %v%v.001..., index: %v%v%v", destDir, ext, destDir, ext, tools.IndexExt)
+		return
 	}
-	log.Printf("[INFO] logfile path: %v.tar.gz", destDir)
+	log.Printf("[INFO] logfile path: %v%v", destDir, ext)
 
 }
 func (ctx Log) getFileSize(namespace, logfile, pod string, host HostInfo) string {
@@ -322,14 +1138,11 @@ func (ctx Log) getFileSize(namespace, logfile, pod string, host HostInfo) string
 			log.Fatalln("get disk info failed")
 		}
 	} else {
-		cli := ssh.SSH{
-			Host:     host.IP,
-			Port:     int64(host.Port),
-			Username: host.User,
-			Password: host.Password,
-			KeyFile:  host.KeyFile,
-		}
-		cli.CreateClient()
+		var cli *ssh.SSH
+		if cli, err = host.sshClient(); err != nil {
+			log.Println(err)
+			return result
+		}
 		result, err = cli.RunShell(cmdStr)
 		if err != nil {
 			log.Println(err)
@@ -339,30 +1152,28 @@ func (ctx Log) getFileSize(namespace, logfile, pod string, host HostInfo) string
 	return result
 }
 
-func (ctx Log) checkSpace(arg Args, logfile, pod string, host HostInfo) bool {
+// checkSpace 返回远程文件大小(字节), 以及下载后本地磁盘使用率是否小于85%;
+// 获取 pod 中的文件大小失败时返回 err, 由调用者记录到汇总中
+func (ctx Log) checkSpace(arg Args, logfile, pod string, host HostInfo) (int64, bool, error) {
 
 	if sysType == "windows" {
 		log.Println("This check is not supported. Please make your own judgment")
-		return true
+		return 0, true, nil
 	}
 	var result string
 	var err error
-	cmdStr := fmt.Sprintf("du -k %v|awk '{print \\$1}'", logfile)
+	cmdStr := fmt.Sprintf("du -sk %v|awk '{print \\$1}'", logfile)
 	if ctx.Type == "k8s" {
-		cmdStr1 := fmt.Sprintf("du -k %v|awk '{print $1}'", logfile)
+		cmdStr1 := fmt.Sprintf("du -sk %v|awk '{print $1}'", logfile)
 		result, err = k8s.Exec(kubeConfig, clientSet, pod, ctx.NS, cmdStr1, ctx.Container)
 		if err != nil {
-			log.Fatalln("[ERROR] get disk info failed", cmdStr1, err)
+			return 0, false, &tools.NewError{Msg: "get disk info failed: " + err.Error()}
 		}
 	} else {
-		cli := ssh.SSH{
-			Host:     host.IP,
-			Port:     int64(host.Port),
-			Username: host.User,
-			Password: host.Password,
-			KeyFile:  host.KeyFile,
-		}
-		cli.CreateClient()
+		var cli *ssh.SSH
+		if cli, err = host.sshClient(); err != nil {
+			return 0, false, err
+		}
 		result, err = cli.RunShell(cmdStr)
 		if err != nil {
 			log.Println(err)
@@ -372,11 +1183,11 @@ func (ctx Log) checkSpace(arg Args, logfile, pod string, host HostInfo) bool {
 	fileSizeStr := result
 	diskInfo := tools.CurDiskInfo(*arg.LogDir)
 
-	fileSize, _ := strconv.ParseInt(fileSizeStr, 10, 64)
+	fileSize, _ := strconv.ParseInt(strings.TrimSpace(fileSizeStr), 10, 64)
 	diskAll, _ := strconv.ParseInt(diskInfo[0], 10, 64)
 	diskUsed, _ := strconv.ParseInt(diskInfo[1], 10, 64)
 
-	return (fileSize+diskUsed)/diskAll*100 < 85
+	return fileSize * 1024, (fileSize+diskUsed)/diskAll*100 < 85, nil
 
 }
 
@@ -401,14 +1212,10 @@ func (ctx Log) regToRealDir(pod string, host HostInfo) (string, error) {
 				result, err = k8s.Exec(kubeConfig, clientSet, pod, ctx.NS, cmdStr, ctx.Container)
 				path = tools.Strip(result, "\n")
 			} else {
-				cli := ssh.SSH{
-					Host:     host.IP,
-					Port:     int64(host.Port),
-					Username: host.User,
-					Password: host.Password,
-					KeyFile:  host.KeyFile,
+				var cli *ssh.SSH
+				if cli, err = host.sshClient(); err != nil {
+					return "", err
 				}
-				cli.CreateClient()
 				result, err = cli.RunShell(cmdStr)
 				if err != nil {
 					log.Println(cmdStr, err)
@@ -426,7 +1233,6 @@ func (ctx Log) regToRealDir(pod string, host HostInfo) (string, error) {
 }
 
 func (ctx Log) regToRealFile(oldPath, pod string, host HostInfo) (string, error) {
-	path := ""
 	var result string
 	var cmdStr string
 	var err error
@@ -445,21 +1251,15 @@ func (ctx Log) regToRealFile(oldPath, pod string, host HostInfo) (string, error)
 			return tools.Strip(result, "\n"), nil
 		}
 	} else {
-		cli := ssh.SSH{
-			Host:     host.IP,
-			Port:     int64(host.Port),
-			Username: host.User,
-			Password: host.Password,
-			KeyFile:  host.KeyFile,
-		}
-		cli.CreateClient()
+		var cli *ssh.SSH
+		if cli, err = host.sshClient(); err != nil {
+			return "", err
+		}
 		result, err = cli.RunShell(cmdStr)
 		if err != nil {
 			return "", &tools.NewError{Msg: fmt.Sprintf(cmdStr, result, err)}
 		} else {
-			dirPath := strings.Split(result, "\n")
-			path = dirPath[len(dirPath)-1]
-			return path, nil
+			return result, nil
 		}
 	}
 }
@@ -467,36 +1267,136 @@ func (ctx Log) regToRealFile(oldPath, pod string, host HostInfo) (string, error)
 func main() {
 	arg := Args{}
 
-	arg.Mode = flag.String("m", "", "mode: list/get")
+	arg.Mode = flag.String("m", "", "mode: list/get/join/decrypt")
 	arg.Name = flag.String("n", "", "log name (log1,log2)")
 	arg.LogDir = flag.String("d", "/tmp/logs", "dest logs dir")
 	arg.HostYaml = flag.String("i", "./host.yml", "host.yml")
 	arg.ConfYaml = flag.String("c", "./conf.yml", "conf.yml")
 	arg.Debug = flag.Bool("debug", false, "debug")
-	arg.Limit = flag.Int("limit", 0, "Limit Max Speed: 1MB/s (0=unlimited)")
+	arg.Limit = flag.Int("limit", 0, "Limit Max Speed of all transfers: 1MB/s (0=unlimited)")
+	arg.HostLimit = flag.Int("host-limit", 0, "Limit Max Speed of each host/pod: 1MB/s (0=unlimited)")
+	arg.Parallel = flag.Int("parallel", 5, "max hosts/pods collected at the same time")
+	arg.Retry = flag.Int("retry", 3, "retry times of connect/exec/download")
+	arg.RetryWait = flag.Duration("retry-wait", time.Second, "wait before first retry, doubled every retry")
+	arg.Resume = flag.Bool("resume", false, "resume unfinished ssh downloads of last run")
+	arg.Since = flag.String("since", "", "only files modified after: 2006-01-02 15:04:05, 15:04, 2h, 1d")
+	arg.Until = flag.String("until", "", "only files with logs before: 2006-01-02 15:04:05, 15:04, 30m")
+	arg.Grep = flag.String("grep", "", "only download lines matching the regexp (grep -E on remote host/pod)")
+	arg.Context = flag.Int("context", 0, "lines of context around each -grep match")
+	arg.Format = flag.String("format", tools.FormatTarGz, "output format: tar.gz/tar.zst/zip/dir (dir: keep the directory)")
+	arg.SplitSize = flag.String("split-size", "", "split each archive into volumes of this size: 100M, 1G (join with -m join xxx.index)")
+	arg.Passphrase = flag.String("passphrase", "", "encrypt archives with passphrase, or decrypt with -m decrypt (env LOG_COLLECT_PASSPHRASE)")
+	arg.Recipient = flag.String("recipient", "", "encrypt archives to age public keys (age1...,age1...) or files of keys")
+	arg.Identity = flag.String("identity", "", "age private key file for -m decrypt")
+	arg.Verify = flag.Bool("verify", true, "verify downloaded files with remote sha256sum (false: only compare size)")
 	flag.Parse()
 
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
 	tools.DEBUG = *arg.Debug
 	tools.Limit = *arg.Limit
+	tools.HostLimit = *arg.HostLimit
+	tools.Retries = *arg.Retry
+	if *arg.RetryWait < 0 {
+		log.Fatal("-retry-wait can not be negative")
+	}
+	tools.RetryWait = *arg.RetryWait
+	tools.Resume = *arg.Resume
+	tools.Verify = *arg.Verify
+	if _, err := tools.ArchiveExt(*arg.Format); err != nil {
+		log.Fatal(err)
+	}
+	if *arg.SplitSize != "" {
+		size, err := tools.ParseSize(*arg.SplitSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tools.SplitSize = size
+		if *arg.Format == tools.FormatDir {
+			log.Println("[WARN] -split-size is ignored with -format dir")
+		}
+	}
+	tools.Passphrase = *arg.Passphrase
+	if tools.Passphrase == "" {
+		tools.Passphrase = os.Getenv("LOG_COLLECT_PASSPHRASE")
+	}
+	if *arg.Recipient != "" {
+		recipients, err := tools.ParseRecipients(strings.Split(*arg.Recipient, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+		tools.Recipients = recipients
+	}
+	if *arg.Mode == "decrypt" {
+		for _, file := range flag.Args() {
+			if !strings.HasSuffix(file, tools.EncryptExt) {
+				log.Fatalln("[ERROR] not an encrypted file:", file)
+			}
+			dest := strings.TrimSuffix(file, tools.EncryptExt)
+			if err := tools.Decrypt(file, dest, *arg.Identity); err != nil {
+				log.Fatalln("[ERROR] decrypt", file, err)
+			}
+			log.Printf("[INFO] logfile path: %v", dest)
+		}
+		return
+	}
+	if tools.Encrypting() {
+		if err := tools.CheckEncrypt(); err != nil {
+			log.Fatal(err)
+		}
+		if *arg.Format == tools.FormatDir {
+			log.Fatal("-format dir can not be encrypted")
+		}
+	}
+	if *arg.Mode == "join" {
+		// 不需要配置文件
+		for _, indexFile := range flag.Args() {
+			archive, err := tools.Join(indexFile)
+			if err != nil {
+				log.Fatalln("[ERROR] join", indexFile, err)
+			}
+			log.Printf("[INFO] logfile path: %v", archive)
+		}
+		return
+	}
 	conf, err := ReadYamlConfig(*arg.ConfYaml)
 	if err != nil {
 		log.Fatal(err)
 	}
 	if *arg.Mode == "get" {
 		logList := strings.Split(*arg.Name, ",")
+		redactor, err := tools.NewRedactor(conf.Redact)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tools.StartProgress()
 
 		for _, logName := range logList {
 			logInfo := conf.getLogNameList(logName)
 			if logInfo.Name == "" {
 				log.Println("not found log: ", logName)
+				summary.Add(tools.Result{Log: logName, Target: "-", File: "-"}, time.Now(),
+					&tools.NewError{Msg: "not found log"})
 			} else {
 				conf.HostGroups = conf.ReadHost(*arg.HostYaml)
 				conf.UpdateHosts()
 				logInfo.HostInfo = logInfo.GetLogHost(*conf)
+				logInfo.groupLimit = conf.HostGroups[logInfo.HostGroup].Limit
+				logInfo.redactor = redactor
 				logInfo.fetchLogFile(arg)
 			}
 		}
+		ssh.CloseAll()
+		tools.StopProgress()
+		summary.Print(os.Stdout)
+		summaryFile := filepath.Join(*arg.LogDir, "summary.json")
+		if err := summary.WriteJSON(summaryFile); err != nil {
+			log.Println("[ERROR] write summary failed:", err)
+		} else {
+			log.Printf("[INFO] summary path: %v", summaryFile)
+		}
+		if summary.Failed() {
+			os.Exit(1)
+		}
 
 	} else if *arg.Mode == "list" {
 		for _, logItem := range conf.Logs {
@@ -505,6 +1405,6 @@ func main() {
 		fmt.Println("----------------------------------")
 		fmt.Println("Usage: ./log-collect -m get -n xxx")
 	} else {
-		log.Println("Usage: ./log-collect -m get/list")
+		log.Println("Usage: ./log-collect -m get/list/join/decrypt")
 	}
 }
@@ -0,0 +1,112 @@
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"log-collect/tools"
+	"strings"
+	"time"
+)
+
+const (
+	RuntimeDocker = "docker"
+	RuntimeCrictl = "crictl"
+)
+
+// Container 主机上的容器, Pod 只有 crictl 的容器才有
+type Container struct {
+	ID   string
+	Name string
+	Pod  string
+}
+
+// ContainerRuntime docker or crictl found on host, docker first
+func (ctx *SSH) ContainerRuntime() (string, error) {
+	for _, runtime := range []string{RuntimeDocker, RuntimeCrictl} {
+		if _, err := ctx.RunShell("command -v " + runtime); err == nil {
+			return runtime, nil
+		}
+	}
+	return "", &tools.NewError{Msg: "docker or crictl not found"}
+}
+
+// ListContainers running containers with all labels (key=value), all: include exited containers
+func (ctx *SSH) ListContainers(runtime string, labels []string, all bool) ([]Container, error) {
+	args := []string{runtime, "ps"}
+	if all {
+		args = append(args, "-a")
+	}
+	if runtime == RuntimeCrictl {
+		for _, label := range labels {
+			args = append(args, "--label", tools.ShellQuote(label))
+		}
+		result, err := ctx.RunShell(strings.Join(append(args, "-o", "json"), " "))
+		if err != nil {
+			return nil, &tools.NewError{Msg: result + err.Error()}
+		}
+		return parseCrictlPs(result)
+	}
+	for _, label := range labels {
+		args = append(args, "--filter", tools.ShellQuote("label="+label))
+	}
+	args = append(args, "--no-trunc", "--format", tools.ShellQuote("{{.ID}} {{.Names}}"))
+	result, err := ctx.RunShell(strings.Join(args, " "))
+	if err != nil {
+		return nil, &tools.NewError{Msg: result + err.Error()}
+	}
+	var containers []Container
+	for _, line := range strings.Split(result, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			containers = append(containers, Container{ID: fields[0], Name: fields[1]})
+		}
+	}
+	return containers, nil
+}
+
+func parseCrictlPs(result string) ([]Container, error) {
+	var ps struct {
+		Containers []struct {
+			ID       string `json:"id"`
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Labels map[string]string `json:"labels"`
+		} `json:"containers"`
+	}
+	// RunShell 的输出包含 stderr, 跳过 crictl 的警告
+	if index := strings.Index(result, "{"); index > 0 {
+		result = result[index:]
+	}
+	if err := json.Unmarshal([]byte(result), &ps); err != nil {
+		return nil, &tools.NewError{Msg: "parse crictl ps failed: " + err.Error()}
+	}
+	var containers []Container
+	for _, item := range ps.Containers {
+		containers = append(containers, Container{
+			ID:   item.ID,
+			Name: item.Metadata.Name,
+			Pod:  item.Labels["io.kubernetes.pod.name"],
+		})
+	}
+	return containers, nil
+}
+
+// ContainerLogsCommand logs command of container, stderr of container is merged into stdout;
+// crictl does not support until
+func ContainerLogsCommand(runtime, id string, since, until time.Time, tail string, timestamps bool) string {
+	args := []string{runtime, "logs"}
+	if !since.IsZero() {
+		args = append(args, "--since", since.UTC().Format(time.RFC3339))
+	}
+	if !until.IsZero() && runtime == RuntimeDocker {
+		args = append(args, "--until", until.UTC().Format(time.RFC3339))
+	}
+	if tail != "" {
+		args = append(args, "--tail", tools.ShellQuote(tail))
+	}
+	if timestamps {
+		args = append(args, "--timestamps")
+	}
+	return fmt.Sprintf("%v %v 2>&1", strings.Join(args, " "), tools.ShellQuote(id))
+}
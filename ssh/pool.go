@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// 同一主机的所有操作复用一个 ssh/sftp 连接, 避免重复登录被堡垒机限制
+var pool = struct {
+	sync.Mutex
+	clients map[string]*poolEntry
+}{clients: make(map[string]*poolEntry)}
+
+type poolEntry struct {
+	sync.Mutex
+	cli *SSH
+}
+
+func poolKey(conf *SSH) string {
+	return fmt.Sprintf("%s@%s:%d", conf.Username, conf.Host, conf.Port)
+}
+
+// GetClient Get connected client of host from pool, connect if not exist
+func GetClient(conf *SSH) (*SSH, error) {
+	key := poolKey(conf)
+	pool.Lock()
+	entry, ok := pool.clients[key]
+	if !ok {
+		entry = &poolEntry{}
+		pool.clients[key] = entry
+	}
+	pool.Unlock()
+
+	// 只锁当前主机, 不同主机可以同时建立连接
+	entry.Lock()
+	defer entry.Unlock()
+	if entry.cli != nil {
+		return entry.cli, nil
+	}
+	cli := &SSH{
+		Host:     conf.Host,
+		Port:     conf.Port,
+		Username: conf.Username,
+		Password: conf.Password,
+		KeyFile:  conf.KeyFile,
+	}
+	if err := cli.CreateClient(); err != nil {
+		return nil, err
+	}
+	entry.cli = cli
+	return cli, nil
+}
+
+// CloseAll Close all client in pool
+func CloseAll() {
+	pool.Lock()
+	defer pool.Unlock()
+	for key, entry := range pool.clients {
+		entry.Lock()
+		if entry.cli != nil {
+			if err := entry.cli.Close(); err != nil {
+				log.Println("[WARN] close connection failed:", key, err)
+			}
+		}
+		entry.Unlock()
+		delete(pool.clients, key)
+	}
+}
@@ -1,6 +1,8 @@
 package ssh
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,6 +12,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -26,6 +29,8 @@ type SSH struct {
 	sshClient  *ssh.Client  //ssh client
 	sftpClient *sftp.Client //sftp client
 	LastResult string       //最近一次运行的结果
+	lock       sync.Mutex
+	connLock   sync.Mutex // 保护 sshClient/sftpClient, 断线重连时替换
 }
 
 func publicKeyAuthFunc(keyPath string) ssh.AuthMethod {
@@ -42,13 +47,16 @@ func publicKeyAuthFunc(keyPath string) ssh.AuthMethod {
 }
 
 // CreateClient Create SSH Client
-func (ctx *SSH) CreateClient() {
-	var (
-		sshClient  *ssh.Client
-		sftpClient *sftp.Client
-		err        error
-	)
+func (ctx *SSH) CreateClient() error {
+	return tools.Retry(fmt.Sprintf("connect %s:%d", ctx.Host, ctx.Port), func() error {
+		ctx.connLock.Lock()
+		defer ctx.connLock.Unlock()
+		return ctx.dial()
+	})
+}
 
+// dial 建立 ssh 和 sftp 连接, 调用者持有 connLock
+func (ctx *SSH) dial() error {
 	config := ssh.ClientConfig{
 		User: ctx.Username,
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
@@ -63,41 +71,171 @@ func (ctx *SSH) CreateClient() {
 	}
 	addr := fmt.Sprintf("%s:%d", ctx.Host, ctx.Port)
 
-	if sshClient, err = ssh.Dial("tcp", addr, &config); err != nil {
-		log.Println("[ERROR] connect host failed:", err)
+	sshClient, err := ssh.Dial("tcp", addr, &config)
+	if err != nil {
+		// 认证失败重试没有意义, 还可能导致账号被锁
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return tools.NoRetry(&tools.NewError{Msg: "connect host failed: " + err.Error()})
+		}
+		return &tools.NewError{Msg: "connect host failed: " + err.Error()}
 	}
-	ctx.sshClient = sshClient
 
 	//此时获取了sshClient，下面使用sshClient构建sftpClient
-	if sftpClient, err = sftp.NewClient(sshClient); err != nil {
-		log.Println("[ERROR] error occurred:", err)
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return &tools.NewError{Msg: "create sftp client failed: " + err.Error()}
 	}
+	ctx.sshClient = sshClient
 	ctx.sftpClient = sftpClient
+	return nil
+}
+
+// clients current ssh and sftp client, replaced after reconnect
+func (ctx *SSH) clients() (*ssh.Client, *sftp.Client) {
+	ctx.connLock.Lock()
+	defer ctx.connLock.Unlock()
+	return ctx.sshClient, ctx.sftpClient
+}
+
+// reconnect 关闭断开的连接(vpn 断线等)并重新连接; 同一主机的其他任务已经重连过时不再重连
+func (ctx *SSH) reconnect(broken *ssh.Client) error {
+	ctx.connLock.Lock()
+	defer ctx.connLock.Unlock()
+	if ctx.sshClient != nil && ctx.sshClient != broken {
+		return nil
+	}
+	log.Printf("[WARN] %v connection lost, reconnect", ctx.Host)
+	_ = ctx.close()
+	return ctx.dial()
+}
+
+// alive 通过 keepalive 请求检查连接是否可用
+func alive(client *ssh.Client) bool {
+	if client == nil {
+		return false
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err == nil
+	case <-time.After(10 * time.Second):
+		return false
+	}
+}
+
+// retry 同 tools.Retry, 失败后连接已断开时, 下一次尝试前重新连接
+func (ctx *SSH) retry(desc string, fn func() error) error {
+	var broken *ssh.Client
+	return tools.Retry(desc, func() error {
+		if err := ctx.reconnect(broken); err != nil {
+			return err
+		}
+		client, _ := ctx.clients()
+		err := fn()
+		broken = nil
+		var exitErr *ssh.ExitError
+		if err != nil && !errors.As(err, &exitErr) && !alive(client) {
+			broken = client
+		}
+		return err
+	})
+}
+
+// Close close sftp and ssh client
+func (ctx *SSH) Close() error {
+	ctx.connLock.Lock()
+	defer ctx.connLock.Unlock()
+	return ctx.close()
+}
+
+func (ctx *SSH) close() error {
+	if ctx.sftpClient != nil {
+		_ = ctx.sftpClient.Close()
+		ctx.sftpClient = nil
+	}
+	if ctx.sshClient != nil {
+		err := ctx.sshClient.Close()
+		ctx.sshClient = nil
+		return err
+	}
+	return nil
 }
 
 // RunShell Run cmd
 func (ctx *SSH) RunShell(shell string) (res string, error1 error) {
-	var (
-		session *ssh.Session
-		err     error
-	)
-	//获取session，这个session是用来远程执行操作的
-	if session, err = ctx.sshClient.NewSession(); err != nil {
-		return "", err
-	}
-	//执行shell
-	if output, err := session.CombinedOutput(shell); err != nil {
+	var output []byte
+	err := ctx.retry(fmt.Sprintf("%v run %v", ctx.Host, shell), func() error {
+		//获取session，这个session是用来远程执行操作的
+		sshClient, _ := ctx.clients()
+		session, err := sshClient.NewSession()
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		//执行shell
+		output, err = session.CombinedOutput(shell)
+		if _, ok := err.(*ssh.ExitError); ok {
+			// 命令已执行, 返回码非0
+			return tools.NoRetry(err)
+		}
+		return err
+	})
+	if err != nil {
 		return "", err
-	} else {
-		ctx.LastResult = tools.Strip(string(output), "\n")
 	}
-	return ctx.LastResult, nil
+	res = tools.Strip(string(output), "\n")
+	ctx.lock.Lock()
+	ctx.LastResult = res
+	ctx.lock.Unlock()
+	return res, nil
+}
+
+// DownloadCommand run cmd and save its stdout to dstPath, return downloaded bytes
+func (ctx *SSH) DownloadCommand(cmd, dstPath string, stream tools.Stream) (int64, error) {
+	stream = stream.WithBucket(tools.HostBucket(ctx.Host))
+	var size int64
+	err := ctx.retry(fmt.Sprintf("%v run %v", ctx.Host, cmd), func() error {
+		sshClient, _ := ctx.clients()
+		session, err := sshClient.NewSession()
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+		stdout, err := session.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		var stderr bytes.Buffer
+		session.Stderr = &stderr
+		if err = session.Start(cmd); err != nil {
+			return err
+		}
+		transfer := tools.NewTransfer(ctx.Host+":"+cmd, 0)
+		defer transfer.Done()
+		size, err = tools.LimitDownload(transfer.Reader(stdout), dstPath, stream)
+		if err != nil {
+			return err
+		}
+		err = tools.WithStderr(session.Wait(), stderr.String())
+		if _, ok := tools.ExitStatus(err); ok {
+			// 命令已执行, 返回码非0
+			return tools.NoRetry(err)
+		}
+		return err
+	})
+	return size, err
 }
 
 // Upload Upload file
 func (ctx *SSH) Upload(srcPath, dstPath string) error {
-	srcFile, _ := os.Open(srcPath)               //本地
-	dstFile, _ := ctx.sftpClient.Create(dstPath) //远程
+	_, sftpClient := ctx.clients()
+	srcFile, _ := os.Open(srcPath)           //本地
+	dstFile, _ := sftpClient.Create(dstPath) //远程
 	defer func() {
 		_ = srcFile.Close()
 		_ = dstFile.Close()
@@ -127,7 +265,8 @@ func (ctx *SSH) UploadDirectory(srcDir, dstPath string) error {
 		srcFilePath := path.Join(srcDir, backupDir.Name())
 		dstFilePath := path.Join(dstPath, backupDir.Name())
 		if backupDir.IsDir() {
-			ctx.sftpClient.Mkdir(dstFilePath)
+			_, sftpClient := ctx.clients()
+			sftpClient.Mkdir(dstFilePath)
 			ctx.UploadDirectory(srcFilePath, dstFilePath)
 		} else {
 			ctx.Upload(srcFilePath, dstFilePath)
@@ -136,50 +275,212 @@ func (ctx *SSH) UploadDirectory(srcDir, dstPath string) error {
 	return nil
 }
 
-// Download file
-func (ctx *SSH) Download(srcPath, dstPath string) error {
-	fileObj, _ := ctx.sftpClient.Stat(srcPath)
+// Download file, return downloaded bytes
+func (ctx *SSH) Download(srcPath, dstPath string, stream tools.Stream) (int64, error) {
+	fileObj, err := ctx.Stat(srcPath)
+	if err != nil {
+		return 0, err
+	}
 	if fileObj.IsDir() {
-		err := ctx.DownloadDirectory(srcPath, dstPath)
-		if err != nil {
-			return err
+		return ctx.DownloadDirectory(srcPath, dstPath, stream)
+	}
+	stream = stream.WithBucket(tools.HostBucket(ctx.Host))
+	var size int64
+	err = ctx.retry(fmt.Sprintf("%v download %v", ctx.Host, srcPath), func() error {
+		var err error
+		// 过滤后的数据与远程文件不同, 不能续传和校验
+		if tools.Resume && !stream.Filtered() {
+			size, err = ctx.resumeDownload(srcPath, dstPath, fileObj, stream)
+		} else {
+			size, err = ctx.download(srcPath, dstPath, fileObj, stream)
 		}
-	} else {
-		srcFile, _ := ctx.sftpClient.Open(srcPath) //远程
-		err := tools.LimitDownload(srcFile, dstPath)
-		if err != nil {
+		if err != nil || stream.Filtered() {
 			return err
 		}
+		if err = ctx.verify(srcPath, dstPath, fileObj); err != nil {
+			// 删除校验失败的文件, 重试时重新下载
+			_ = os.Remove(dstPath)
+		}
+		return err
+	})
+	return size, err
+}
+
+// DownloadCompressed compress on remote host before transfer, directory is packed by tar;
+// filtered file is decompressed locally. return the saved file and downloaded bytes
+func (ctx *SSH) DownloadCompressed(srcPath, dstPath string, codec *tools.Codec, stream tools.Stream) (string, int64, error) {
+	fileObj, err := ctx.Stat(srcPath)
+	if err != nil {
+		return dstPath, 0, err
+	}
+	srcPath = path.Clean(srcPath)
+	quoted := tools.ShellQuote(srcPath)
+	var cmd string
+	switch {
+	case fileObj.IsDir() && stream.Filtered():
+		// 过滤只能处理单个文件的内容
+		size, err := ctx.DownloadDirectory(srcPath, dstPath, stream)
+		return dstPath, size, err
+	case fileObj.IsDir():
+		cmd = fmt.Sprintf("tar cf - %v -C %v %v", codec.TarOpt,
+			tools.ShellQuote(path.Dir(srcPath)), tools.ShellQuote(path.Base(srcPath)))
+		dstPath += ".tar" + codec.Ext
+	case stream.Filtered():
+		cmd = codec.Cmd + " -- " + quoted
+		stream = stream.WithDecoder(codec.Decoder())
+	default:
+		cmd = codec.Cmd + " -- " + quoted
+		dstPath += codec.Ext
+	}
+	size, err := ctx.DownloadCommand(cmd, dstPath, stream)
+	return dstPath, size, err
+}
+
+func (ctx *SSH) download(srcPath, dstPath string, remote os.FileInfo, stream tools.Stream) (int64, error) {
+	_, sftpClient := ctx.clients()
+	srcFile, err := sftpClient.Open(srcPath) //远程
+	if err != nil {
+		return 0, err
+	}
+	defer srcFile.Close()
+	transfer := tools.NewTransfer(ctx.Host+":"+srcPath, remote.Size())
+	defer transfer.Done()
+	return tools.LimitDownload(transfer.Reader(srcFile), dstPath, stream)
+}
+
+// verify 本地文件与远程文件的前 N 字节(N=本地文件大小)对比 sha256, 日志文件下载过程中还在写入也能校验;
+// 远程没有 sha256sum 或 -verify=false 时只校验大小
+func (ctx *SSH) verify(srcPath, dstPath string, remote os.FileInfo) error {
+	local, err := os.Stat(dstPath)
+	if err != nil {
+		return err
+	}
+	if local.Size() < remote.Size() {
+		return &tools.NewError{Msg: fmt.Sprintf("size mismatch: local %v, remote %v", local.Size(), remote.Size())}
+	}
+	if !tools.Verify {
+		return nil
+	}
+	cmd := fmt.Sprintf("head -c %d %v | sha256sum", local.Size(), tools.ShellQuote(srcPath))
+	result, err := ctx.RunShell(cmd)
+	remoteSum := tools.ParseSHA256(result)
+	if err != nil || remoteSum == "" {
+		log.Printf("[WARN] %v sha256sum not available, only size is verified: %v", ctx.Host, srcPath)
+		return nil
+	}
+	localSum, err := tools.FileSHA256(dstPath)
+	if err != nil {
+		return err
+	}
+	if localSum != remoteSum {
+		return &tools.NewError{Msg: fmt.Sprintf("sha256 mismatch: local %v, remote %v", localSum, remoteSum)}
+	}
+	if tools.DEBUG {
+		log.Println("[DEBUG] sha256 verified", ctx.Host, srcPath, localSum)
 	}
 	return nil
 }
 
+// resumeDownload 断点续传: 先写入 dstPath.part, 远程文件大小和修改时间不变时从本地大小继续下载
+func (ctx *SSH) resumeDownload(srcPath, dstPath string, remote os.FileInfo, stream tools.Stream) (int64, error) {
+	// 上次已经下载完成
+	if local, err := os.Stat(dstPath); err == nil &&
+		local.Size() == remote.Size() && local.ModTime().Equal(remote.ModTime()) {
+		log.Printf("[INFO] %v already downloaded: %v", srcPath, dstPath)
+		return local.Size(), nil
+	}
+	partFile := dstPath + ".part"
+	infoFile := partFile + ".info"
+	info := fmt.Sprintf("%d %d", remote.Size(), remote.ModTime().Unix())
+	var offset int64
+	if old, err := ioutil.ReadFile(infoFile); err == nil {
+		local, err := os.Stat(partFile)
+		if string(old) == info && err == nil && local.Size() <= remote.Size() {
+			offset = local.Size()
+		} else {
+			log.Printf("[WARN] %v changed since last download, download from start", srcPath)
+		}
+	}
+	if offset == 0 {
+		if err := ioutil.WriteFile(infoFile, []byte(info), 0644); err != nil {
+			return 0, err
+		}
+	} else {
+		log.Printf("[INFO] resume %v from %v", srcPath, tools.HumanSize(offset))
+	}
+
+	_, sftpClient := ctx.clients()
+	srcFile, err := sftpClient.Open(srcPath) //远程
+	if err != nil {
+		return 0, err
+	}
+	defer srcFile.Close()
+	if _, err := srcFile.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	transfer := tools.NewTransfer(ctx.Host+":"+srcPath, remote.Size())
+	defer transfer.Done()
+	transfer.Add(offset)
+	size, err := tools.LimitDownloadAt(transfer.Reader(srcFile), partFile, offset, stream)
+	if err != nil {
+		return offset + size, err
+	}
+	if err := os.Rename(partFile, dstPath); err != nil {
+		return offset + size, err
+	}
+	_ = os.Remove(infoFile)
+	// 保留远程修改时间, 用于下次判断是否已经下载完成
+	_ = os.Chtimes(dstPath, remote.ModTime(), remote.ModTime())
+	return offset + size, nil
+}
+
 // DownloadDirectory Download Directory
-func (ctx *SSH) DownloadDirectory(srcPath, dstPath string) error {
-	w := ctx.sftpClient.Walk(srcPath)
+func (ctx *SSH) DownloadDirectory(srcPath, dstPath string, stream tools.Stream) (int64, error) {
+	var total int64
+	_, sftpClient := ctx.clients()
+	w := sftpClient.Walk(srcPath)
 	for w.Step() {
 		if w.Err() != nil {
 			continue
 		}
 		fileName := strings.Split(w.Path(), srcPath)
-		stat, _ := ctx.sftpClient.Stat(w.Path())
+		stat := w.Stat()
 		if stat.IsDir() {
 			err := os.MkdirAll(dstPath+fileName[len(fileName)-1], 0755)
 			if err != nil {
-				return err
+				return total, err
 			}
 		} else {
-			err := ctx.Download(w.Path(), dstPath+fileName[len(fileName)-1])
+			n, err := ctx.Download(w.Path(), dstPath+fileName[len(fileName)-1], stream.File(w.Path()))
+			total += n
 			if err != nil {
-				return err
+				return total, err
 			}
 		}
 
 	}
-	return nil
+	return total, nil
+}
+
+// Stat remote file info, symbolic link is followed
+func (ctx *SSH) Stat(filePath string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := ctx.retry(fmt.Sprintf("%v stat %v", ctx.Host, filePath), func() error {
+		_, sftpClient := ctx.clients()
+		var err error
+		info, err = sftpClient.Stat(filePath)
+		var status *sftp.StatusError
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) || errors.As(err, &status) {
+			// 文件不存在或没有权限, 重试没有意义
+			return tools.NoRetry(err)
+		}
+		return err
+	})
+	return info, err
 }
 
 // Delete delete remote file
 func (ctx *SSH) Delete(filePath string) error {
-	return ctx.sftpClient.Remove(filePath)
+	_, sftpClient := ctx.clients()
+	return sftpClient.Remove(filePath)
 }
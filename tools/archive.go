@@ -0,0 +1,205 @@
+package tools
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+	FormatZip    = "zip"
+	FormatDir    = "dir" // 不打包, 保留目录
+)
+
+// ArchiveExt extension of the archive, "" for dir
+func ArchiveExt(format string) (string, error) {
+	switch format {
+	case FormatTarGz, FormatTarZst, FormatZip:
+		return "." + format, nil
+	case FormatDir:
+		return "", nil
+	}
+	return "", &NewError{Msg: "invalid format: " + format + ", support tar.gz/tar.zst/zip/dir"}
+}
+
+// ArchiveFormat format of the archive by extension, tar.gz if unknown
+func ArchiveFormat(file string) string {
+	for _, format := range []string{FormatTarZst, FormatZip} {
+		if strings.HasSuffix(file, "."+format) {
+			return format
+		}
+	}
+	return FormatTarGz
+}
+
+// WriteArchive pack files to w, directory is packed recursively
+func WriteArchive(w io.Writer, files []string, format string) error {
+	if format == FormatZip {
+		zw := zip.NewWriter(w)
+		for _, file := range files {
+			if err := zipCompress(file, filepath.Base(file), zw); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	}
+	var cw io.WriteCloser
+	if format == FormatTarZst {
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		cw = zw
+	} else {
+		cw = gzip.NewWriter(w)
+	}
+	tw := tar.NewWriter(cw)
+	for _, file := range files {
+		srcFile, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		if err := compress(srcFile, "", tw); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return cw.Close()
+}
+
+func zipCompress(file, name string, zw *zip.Writer) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		fileInfos, err := os.ReadDir(file)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fileInfos {
+			if err := zipCompress(filepath.Join(file, fi.Name()), name+"/"+fi.Name(), zw); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	srcFile, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	_, err = io.Copy(w, LimitReader(srcFile))
+	return err
+}
+
+// Extract 解压 tar.gz/tar.zst/zip 到 dest, 格式按扩展名判断
+func Extract(file, dest string) error {
+	format := ArchiveFormat(file)
+	if format == FormatZip {
+		return unZip(file, dest)
+	}
+	srcFile, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	return unTar(srcFile, dest, format)
+}
+
+// unTar 解压 tar.gz/tar.zst 数据流
+func unTar(reader io.Reader, dest, format string) error {
+	var cr io.Reader
+	if format == FormatTarZst {
+		zr, err := zstd.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		cr = zr
+	} else {
+		gr, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		cr = gr
+	}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := extractFile(tr, dest, hdr.Name, hdr.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+}
+
+func unZip(file, dest string) error {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		reader, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(reader, dest, f.Name, f.Mode())
+		_ = reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile 写入 dest 下的 name, 不允许写到 dest 之外
+func extractFile(reader io.Reader, dest, name string, mode os.FileMode) error {
+	target := filepath.Join(dest, name)
+	if rel, err := filepath.Rel(dest, target); err != nil || strings.HasPrefix(rel, "..") {
+		return &NewError{Msg: "invalid file path in archive: " + name}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, reader)
+	return err
+}
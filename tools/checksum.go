@@ -0,0 +1,83 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Verify 下载后使用远程 sha256sum 校验, false 时只校验大小
+var Verify = true
+
+// ManifestName 打包时写入每个日志目录, 可以用 sha256sum -c MANIFEST.sha256 校验
+const ManifestName = "MANIFEST.sha256"
+
+// FileSHA256 sha256 of local file
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ParseSHA256 first field of sha256sum output, empty if not a sha256
+func ParseSHA256(output string) string {
+	fields := strings.Fields(output)
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// ShellQuote quote s as one argument of sh
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// WriteManifest write sha256 of all files in dir to dir/MANIFEST.sha256
+func WriteManifest(dir string) error {
+	var files []string
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	var lines []string
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if rel == ManifestName {
+			continue
+		}
+		sum, err := FileSHA256(file)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%v  %v\n", sum, filepath.ToSlash(rel)))
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestName), []byte(strings.Join(lines, "")), 0644)
+}
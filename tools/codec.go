@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// Codec 远程压缩工具, 压缩后的数据直接保存, 或在本地解压后过滤
+type Codec struct {
+	Name   string
+	Ext    string // 压缩文件的扩展名
+	Cmd    string // 压缩文件输出到标准输出
+	TarOpt string // tar 使用该工具压缩的参数
+}
+
+var codecs = map[string]*Codec{
+	CompressGzip: {Name: CompressGzip, Ext: ".gz", Cmd: "gzip -c", TarOpt: "-z"},
+	CompressZstd: {Name: CompressZstd, Ext: ".zst", Cmd: "zstd -c -q", TarOpt: "--use-compress-program=zstd"},
+}
+
+// Fallbacks codecs to try in order when the tool is missing on remote side: zstd -> gzip -> none
+func Fallbacks(name string) ([]*Codec, error) {
+	switch name {
+	case "", CompressNone:
+		return nil, nil
+	case CompressZstd:
+		return []*Codec{codecs[CompressZstd], codecs[CompressGzip]}, nil
+	case CompressGzip:
+		return []*Codec{codecs[CompressGzip]}, nil
+	}
+	return nil, &NewError{Msg: "invalid remote_compress: " + name + ", support gzip/zstd/none"}
+}
+
+// Decoder filter to decompress data compressed by the remote tool
+func (c *Codec) Decoder() Filter {
+	return func(reader io.Reader) io.Reader {
+		return &decodeReader{name: c.Name, reader: reader}
+	}
+}
+
+// decodeReader 第一次读取时创建解压器, 创建失败的错误在 Read 时返回
+type decodeReader struct {
+	name    string
+	reader  io.Reader
+	decoder io.ReadCloser
+	err     error
+}
+
+func (r *decodeReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.decoder == nil {
+		if r.name == CompressZstd {
+			var decoder *zstd.Decoder
+			decoder, r.err = zstd.NewReader(r.reader, zstd.WithDecoderConcurrency(1))
+			if r.err == nil {
+				r.decoder = decoder.IOReadCloser()
+			}
+		} else {
+			r.decoder, r.err = gzip.NewReader(r.reader)
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+	}
+	n, err := r.decoder.Read(p)
+	if err != nil {
+		// 读取结束后释放解压器
+		_ = r.decoder.Close()
+		r.err = err
+	}
+	return n, err
+}
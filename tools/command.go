@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"errors"
+	"strings"
+)
+
+// CommandError 远程命令执行失败, 附带标准错误输出
+type CommandError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *CommandError) Error() string {
+	if e.Stderr == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + e.Stderr
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// WithStderr attach stderr of remote command to err
+func WithStderr(err error, stderr string) error {
+	if err == nil {
+		return nil
+	}
+	return &CommandError{Err: err, Stderr: strings.TrimSpace(stderr)}
+}
+
+// ExitStatus exit code of remote command, false if err is not caused by non-zero exit
+func ExitStatus(err error) (int, bool) {
+	var exitErr interface{ ExitStatus() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
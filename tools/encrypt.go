@@ -0,0 +1,135 @@
+package tools
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+var (
+	Passphrase string   // 使用密码加密打包文件
+	Recipients []string // 使用 age X25519 公钥(age1...)加密, 任意一个对应的私钥都可以解密
+)
+
+// EncryptExt 加密后的文件扩展名: xxx.tar.gz.age
+const EncryptExt = ".age"
+
+// Encrypting passphrase or recipients is set
+func Encrypting() bool {
+	return Passphrase != "" || len(Recipients) > 0
+}
+
+// ParseRecipients age1xxx public key, or file with one public key per line (# comment)
+func ParseRecipients(values []string) ([]string, error) {
+	var recipients []string
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if strings.HasPrefix(value, "age1") {
+			recipients = append(recipients, value)
+			continue
+		}
+		file, err := os.Open(value)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				recipients = append(recipients, line)
+			}
+		}
+		_ = file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return recipients, nil
+}
+
+func ageRecipients() ([]age.Recipient, error) {
+	if Passphrase != "" {
+		if len(Recipients) > 0 {
+			return nil, &NewError{Msg: "passphrase and recipients can not be used together"}
+		}
+		recipient, err := age.NewScryptRecipient(Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	}
+	var recipients []age.Recipient
+	for _, key := range Recipients {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, &NewError{Msg: "invalid recipient " + key + ": " + err.Error()}
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// CheckEncrypt check passphrase and recipients before collecting
+func CheckEncrypt() error {
+	_, err := ageRecipients()
+	return err
+}
+
+// EncryptWriter data written is encrypted to w, Close must be called to flush the last chunk
+func EncryptWriter(w io.Writer) (io.WriteCloser, error) {
+	recipients, err := ageRecipients()
+	if err != nil {
+		return nil, err
+	}
+	return age.Encrypt(w, recipients...)
+}
+
+// Decrypt 解密 src 到 dst, identityFile 为 age 私钥文件, 为空时使用 Passphrase; 失败时删除 dst
+func Decrypt(src, dst, identityFile string) error {
+	var identities []age.Identity
+	if identityFile != "" {
+		file, err := os.Open(identityFile)
+		if err != nil {
+			return err
+		}
+		identities, err = age.ParseIdentities(file)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		identity, err := age.NewScryptIdentity(Passphrase)
+		if err != nil {
+			return err
+		}
+		identities = append(identities, identity)
+	}
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	reader, err := age.Decrypt(srcFile, identities...)
+	if err != nil {
+		return err
+	}
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dstFile, reader)
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// 不保留未完整解密的文件
+		_ = os.Remove(dst)
+	}
+	return err
+}
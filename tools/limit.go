@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"io"
+	"sync"
+
+	"github.com/juju/ratelimit"
+)
+
+var (
+	// HostLimit 单个主机/pod的限速 MB/s, 0=不限制
+	HostLimit = 0
+
+	globalOnce    sync.Once
+	globalBucket  *ratelimit.Bucket // -limit, 所有传输共用
+	sharedLock    sync.Mutex
+	sharedBuckets = make(map[string]*ratelimit.Bucket)
+)
+
+// NewBucket Bucket adding limit MB every second, nil means unlimited
+func NewBucket(limit int) *ratelimit.Bucket {
+	if limit <= 0 {
+		return nil
+	}
+	return ratelimit.NewBucketWithRate(float64(limit*1024000), int64(limit*1024000))
+}
+
+// HostBucket bucket shared by all transfers of host, nil if -host-limit not set
+func HostBucket(host string) *ratelimit.Bucket {
+	if HostLimit <= 0 {
+		return nil
+	}
+	return SharedBucket("host/"+host, HostLimit)
+}
+
+// SharedBucket the same bucket is returned for the same key, created with limit at first call
+func SharedBucket(key string, limit int) *ratelimit.Bucket {
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	bucket, ok := sharedBuckets[key]
+	if !ok {
+		bucket = NewBucket(limit)
+		sharedBuckets[key] = bucket
+	}
+	return bucket
+}
+
+// LimitReader wrap reader with the global bucket and buckets, nil bucket is ignored
+func LimitReader(reader io.Reader, buckets ...*ratelimit.Bucket) io.Reader {
+	globalOnce.Do(func() {
+		globalBucket = NewBucket(Limit)
+	})
+	for _, bucket := range append([]*ratelimit.Bucket{globalBucket}, buckets...) {
+		if bucket != nil {
+			reader = ratelimit.Reader(reader, bucket)
+		}
+	}
+	return reader
+}
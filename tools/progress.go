@@ -0,0 +1,244 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// ProgressInterval 输出不是终端时, 每隔多久打印一次进度
+var ProgressInterval = 10 * time.Second
+
+// Transfer 单个文件的传输进度
+type Transfer struct {
+	Name  string
+	Total int64 // 0=未知
+	done  int64
+	start time.Time
+}
+
+var progress = struct {
+	sync.Mutex
+	transfers []*Transfer
+	finished  int64 // 已结束的传输的字节数
+	lastDone  int64
+	lastTime  time.Time
+	rate      float64
+	tty       bool
+	shown     bool // 终端上当前显示着进度行
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}{}
+
+// NewTransfer register transfer, call Done when finished
+func NewTransfer(name string, total int64) *Transfer {
+	t := &Transfer{Name: name, Total: total, start: time.Now()}
+	progress.Lock()
+	progress.transfers = append(progress.transfers, t)
+	progress.Unlock()
+	return t
+}
+
+// Add add transferred bytes
+func (t *Transfer) Add(n int64) {
+	atomic.AddInt64(&t.done, n)
+}
+
+// Reader count bytes read from reader
+func (t *Transfer) Reader(reader io.Reader) io.Reader {
+	return &transferReader{Reader: reader, t: t}
+}
+
+// Done unregister transfer
+func (t *Transfer) Done() {
+	progress.Lock()
+	defer progress.Unlock()
+	for index, item := range progress.transfers {
+		if item == t {
+			progress.transfers = append(progress.transfers[:index], progress.transfers[index+1:]...)
+			progress.finished += atomic.LoadInt64(&t.done)
+			break
+		}
+	}
+}
+
+type transferReader struct {
+	io.Reader
+	t *Transfer
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.t.Add(int64(n))
+	return n, err
+}
+
+// progressWriter 终端上先清除进度行再输出日志, 下次刷新时重新显示
+type progressWriter struct {
+	out io.Writer
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	progress.Lock()
+	defer progress.Unlock()
+	if progress.shown {
+		fmt.Fprint(w.out, "\r\033[K")
+		progress.shown = false
+	}
+	return w.out.Write(p)
+}
+
+// StartProgress show progress of all transfers until StopProgress,
+// a live line on terminal, otherwise log every ProgressInterval
+func StartProgress() {
+	progress.Lock()
+	progress.tty = term.IsTerminal(int(os.Stderr.Fd()))
+	progress.lastTime = time.Now()
+	progress.stop = make(chan struct{})
+	progress.Unlock()
+	interval := ProgressInterval
+	if progress.tty {
+		interval = 500 * time.Millisecond
+	}
+	log.SetOutput(&progressWriter{out: os.Stderr})
+	progress.wg.Add(1)
+	go func() {
+		defer progress.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-progress.stop:
+				return
+			case <-ticker.C:
+				showProgress()
+			}
+		}
+	}()
+}
+
+// StopProgress stop showing progress
+func StopProgress() {
+	if progress.stop == nil {
+		return
+	}
+	close(progress.stop)
+	progress.wg.Wait()
+	progress.Lock()
+	if progress.shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		progress.shown = false
+	}
+	progress.stop = nil
+	progress.Unlock()
+	log.SetOutput(os.Stderr)
+}
+
+func showProgress() {
+	progress.Lock()
+	defer progress.Unlock()
+	now := time.Now()
+	done := progress.finished
+	var remain int64
+	for _, t := range progress.transfers {
+		n := atomic.LoadInt64(&t.done)
+		done += n
+		if t.Total > n {
+			remain += t.Total - n
+		}
+	}
+	// 平滑处理瞬时速度
+	if seconds := now.Sub(progress.lastTime).Seconds(); seconds > 0 {
+		rate := float64(done-progress.lastDone) / seconds
+		if progress.rate == 0 {
+			progress.rate = rate
+		} else {
+			progress.rate = progress.rate*0.7 + rate*0.3
+		}
+	}
+	progress.lastDone = done
+	progress.lastTime = now
+	if len(progress.transfers) == 0 {
+		if progress.shown {
+			fmt.Fprint(os.Stderr, "\r\033[K")
+			progress.shown = false
+		}
+		return
+	}
+
+	line := fmt.Sprintf("%v running, %v done, %v/s, ETA %v",
+		len(progress.transfers), HumanSize(done), HumanSize(int64(progress.rate)), eta(remain, progress.rate))
+	if progress.tty {
+		for _, t := range progress.transfers {
+			line += " | " + t.status(now, false)
+		}
+		width, _, err := term.GetSize(int(os.Stderr.Fd()))
+		if err != nil || width <= 0 {
+			width = 120
+		}
+		if runes := []rune(line); len(runes) >= width {
+			line = string(runes[:width-1])
+		}
+		fmt.Fprint(os.Stderr, "\r\033[K"+line)
+		progress.shown = true
+		return
+	}
+	fmt.Fprintln(os.Stderr, logPrefix(now)+"[INFO] progress: "+line)
+	for _, t := range progress.transfers {
+		fmt.Fprintln(os.Stderr, logPrefix(now)+"[INFO]   "+t.status(now, true))
+	}
+}
+
+// status name 45% 450.0MB/1.0GB 5.0MB/s ETA 1m50s
+func (t *Transfer) status(now time.Time, detail bool) string {
+	done := atomic.LoadInt64(&t.done)
+	var items []string
+	if t.Total > 0 {
+		percent := done * 100 / t.Total
+		if percent > 99 {
+			percent = 99
+		}
+		items = append(items, fmt.Sprintf("%v %v%%", t.Name, percent))
+	} else {
+		items = append(items, t.Name)
+	}
+	if !detail {
+		if t.Total <= 0 {
+			items = append(items, HumanSize(done))
+		}
+		return strings.Join(items, " ")
+	}
+	rate := float64(done) / now.Sub(t.start).Seconds()
+	if t.Total > 0 {
+		items = append(items, HumanSize(done)+"/"+HumanSize(t.Total))
+	} else {
+		items = append(items, HumanSize(done))
+	}
+	items = append(items, HumanSize(int64(rate))+"/s")
+	if t.Total > done {
+		items = append(items, "ETA "+eta(t.Total-done, rate))
+	}
+	return strings.Join(items, " ")
+}
+
+func eta(remain int64, rate float64) string {
+	if remain <= 0 {
+		return "-"
+	}
+	if rate < 1 {
+		return "?"
+	}
+	return time.Duration(float64(remain) / rate * float64(time.Second)).Round(time.Second).String()
+}
+
+// logPrefix 与 log.LstdFlags 的格式一致
+func logPrefix(now time.Time) string {
+	return now.Format("2006/01/02 15:04:05 ")
+}
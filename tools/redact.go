@@ -0,0 +1,171 @@
+package tools
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RedactRule 脱敏规则, builtin 和 regex 二选一; replace 支持 $1 引用分组
+type RedactRule struct {
+	Name    string `yaml:"name"`
+	Builtin string `yaml:"builtin"` // ipv4/email/phone/bearer
+	Regex   string `yaml:"regex"`
+	Replace string `yaml:"replace"`
+}
+
+// builtinRules 内置规则, 未设置 replace 时使用这里的替换
+var builtinRules = map[string]RedactRule{
+	"ipv4": {
+		Regex:   `\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`,
+		Replace: "<ipv4>",
+	},
+	"email": {
+		Regex:   `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+		Replace: "<email>",
+	},
+	"phone": {
+		Regex:   `(?:\+86[- ]?|\b86[- ]?|\b)1[3-9]\d{9}\b`,
+		Replace: "<phone>",
+	},
+	"bearer": {
+		Regex:   `(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`,
+		Replace: "${1}<token>",
+	},
+}
+
+type redactRule struct {
+	name    string
+	re      *regexp.Regexp
+	replace []byte
+}
+
+// Redactor 按规则替换下载内容中的敏感信息, 记录每个文件每条规则的替换次数
+type Redactor struct {
+	rules  []redactRule
+	lock   sync.Mutex
+	counts map[string]map[string]int
+}
+
+// NewRedactor nil if no rules
+func NewRedactor(rules []RedactRule) (*Redactor, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &Redactor{counts: make(map[string]map[string]int)}
+	for _, rule := range rules {
+		if rule.Builtin != "" {
+			builtin, ok := builtinRules[rule.Builtin]
+			if !ok {
+				return nil, &NewError{Msg: "unknown redact builtin: " + rule.Builtin + ", support ipv4/email/phone/bearer"}
+			}
+			if rule.Name == "" {
+				rule.Name = rule.Builtin
+			}
+			if rule.Replace == "" {
+				rule.Replace = builtin.Replace
+			}
+			rule.Regex = builtin.Regex
+		}
+		if rule.Regex == "" {
+			return nil, &NewError{Msg: "redact rule " + rule.Name + ": builtin or regex is required"}
+		}
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, &NewError{Msg: "redact rule " + rule.Name + ": " + err.Error()}
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Regex
+		}
+		if rule.Replace == "" {
+			rule.Replace = "***"
+		}
+		r.rules = append(r.rules, redactRule{name: rule.Name, re: re, replace: []byte(rule.Replace)})
+	}
+	return r, nil
+}
+
+// Filter redact each line, replaced count is recorded under key after the whole data is read;
+// each retry creates a new reader, its counts replace those of the failed attempt
+func (r *Redactor) Filter(key string) Filter {
+	return func(reader io.Reader) io.Reader {
+		counts := make(map[string]int)
+		lines := LineReader(reader, func(line []byte) []byte {
+			for _, rule := range r.rules {
+				count := len(rule.re.FindAllIndex(line, -1))
+				if count == 0 {
+					continue
+				}
+				line = rule.re.ReplaceAll(line, rule.replace)
+				counts[rule.name] += count
+			}
+			return line
+		})
+		return &eofReader{reader: lines, eof: func() { r.set(key, counts) }}
+	}
+}
+
+func (r *Redactor) set(key string, counts map[string]int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.counts[key] = counts
+}
+
+// eofReader 第一次读到 EOF 时调用 eof, 中途失败的读取不调用
+type eofReader struct {
+	reader io.Reader
+	eof    func()
+	done   bool
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		r.eof()
+	}
+	return n, err
+}
+
+// Counts replaced count of each rule under key, nil if nothing replaced
+func (r *Redactor) Counts(key string) map[string]int {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.counts[key]) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(r.counts[key]))
+	for name, count := range r.counts[key] {
+		counts[name] = count
+	}
+	return counts
+}
+
+// FileCounts replaced count of each file under directory key, keyed by path relative to the directory
+func (r *Redactor) FileCounts(key string) map[string]map[string]int {
+	if r == nil {
+		return nil
+	}
+	prefix := strings.TrimRight(key, "/") + "/"
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	files := make(map[string]map[string]int)
+	for fileKey, counts := range r.counts {
+		if !strings.HasPrefix(fileKey, prefix) || len(counts) == 0 {
+			continue
+		}
+		fileCounts := make(map[string]int, len(counts))
+		for name, count := range counts {
+			fileCounts[name] = count
+		}
+		files[strings.TrimPrefix(fileKey, prefix)] = fileCounts
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return files
+}
@@ -0,0 +1,76 @@
+package tools
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	redactor, err := NewRedactor([]RedactRule{
+		{Builtin: "ipv4"},
+		{Builtin: "email"},
+		{Builtin: "phone"},
+		{Builtin: "bearer"},
+		{Name: "userid", Regex: `(userid=)\d+`, Replace: "${1}***"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		input  string
+		want   string
+		counts map[string]int
+	}{
+		{"connect 10.0.0.1 -> 192.168.1.255:80\n", "connect <ipv4> -> <ipv4>:80\n", map[string]int{"ipv4": 2}},
+		{"version 1.2.3.400\n", "version 1.2.3.400\n", nil},
+		{"from Bob.Smith@example.com\n", "from <email>\n", map[string]int{"email": 1}},
+		{"call 13812345678, +86 13912345678\n", "call <phone>, <phone>\n", map[string]int{"phone": 2}},
+		{"order 213812345678\n", "order 213812345678\n", nil},
+		{"Authorization: Bearer eyJhbGciOi.J9-x_y==\n", "Authorization: Bearer <token>\n", map[string]int{"bearer": 1}},
+		{"userid=42&userid=7 userid=x\n", "userid=***&userid=*** userid=x\n", map[string]int{"userid": 2}},
+	}
+	for i, test := range tests {
+		key := "file" + string(rune('a'+i))
+		got, err := ioutil.ReadAll(redactor.Filter(key)(strings.NewReader(test.input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%q: got %q, want %q", test.input, got, test.want)
+		}
+		if counts := redactor.Counts(key); !reflect.DeepEqual(counts, test.counts) {
+			t.Errorf("%q: got counts %v, want %v", test.input, counts, test.counts)
+		}
+	}
+}
+
+func TestRedactorCountsAfterEOF(t *testing.T) {
+	redactor, err := NewRedactor([]RedactRule{{Builtin: "ipv4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "10.0.0.1\n10.0.0.2\n10.0.0.3\n"
+	// 中途失败的读取不记录
+	reader := redactor.Filter("dir/app.log")(strings.NewReader(input))
+	if _, err := reader.Read(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if counts := redactor.Counts("dir/app.log"); counts != nil {
+		t.Fatalf("partial read: got counts %v, want nil", counts)
+	}
+	// 重试读取全部内容后记录, 覆盖之前的
+	for i := 0; i < 2; i++ {
+		if _, err := ioutil.ReadAll(redactor.Filter("dir/app.log")(strings.NewReader(input))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]int{"ipv4": 3}
+	if counts := redactor.Counts("dir/app.log"); !reflect.DeepEqual(counts, want) {
+		t.Errorf("got counts %v, want %v", counts, want)
+	}
+	if files := redactor.FileCounts("dir"); !reflect.DeepEqual(files, map[string]map[string]int{"app.log": want}) {
+		t.Errorf("got file counts %v", files)
+	}
+}
@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+// Result 单个文件的拉取结果
+type Result struct {
+	Log       string         `json:"log"`
+	Target    string         `json:"target"`
+	File      string         `json:"file"`
+	Status    string         `json:"status"`
+	Bytes     int64          `json:"bytes"`
+	Truncated bool           `json:"truncated,omitempty"` // 只拉取了文件的末尾(tail_lines/tail_bytes)
+	Redacted  map[string]int `json:"redacted,omitempty"`  // 每条脱敏规则的替换次数
+	Duration  string         `json:"duration"`
+	Error     string         `json:"error,omitempty"`
+	// 目录中每个文件每条脱敏规则的替换次数, key 为目录中的相对路径
+	RedactedFiles map[string]map[string]int `json:"redacted_files,omitempty"`
+}
+
+// Report 本次运行所有日志的拉取结果
+type Report struct {
+	lock    sync.Mutex
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Results []Result  `json:"results"`
+}
+
+func NewReport() *Report {
+	return &Report{Start: time.Now()}
+}
+
+// Add add result, err is saved as error message
+func (r *Report) Add(res Result, start time.Time, err error) {
+	res.Duration = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		res.Error = err.Error()
+		if res.Status == "" {
+			res.Status = StatusFailed
+		}
+	} else if res.Status == "" {
+		res.Status = StatusOK
+	}
+	r.lock.Lock()
+	r.Results = append(r.Results, res)
+	r.lock.Unlock()
+}
+
+// Failed any result failed
+func (r *Report) Failed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, res := range r.Results {
+		if res.Status == StatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Print print results as table
+func (r *Report) Print(w io.Writer) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	count := make(map[string]int)
+	var total int64
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOG\tTARGET\tFILE\tSTATUS\tSIZE\tDURATION\tERROR")
+	for _, res := range r.Results {
+		count[res.Status]++
+		total += res.Bytes
+		size := HumanSize(res.Bytes)
+		if res.Truncated {
+			size += " (truncated)"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			res.Log, res.Target, res.File, res.Status, size, res.Duration, res.Error)
+	}
+	_ = tw.Flush()
+	fmt.Fprintf(w, "total: %v, ok: %v, skipped: %v, failed: %v, size: %v, time: %v\n",
+		len(r.Results), count[StatusOK], count[StatusSkipped], count[StatusFailed],
+		HumanSize(total), time.Since(r.Start).Round(time.Second))
+}
+
+// LogReport results of one log, saved in the bundle of the log
+func (r *Report) LogReport(name string) *Report {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	report := &Report{Start: r.Start}
+	for _, res := range r.Results {
+		if res.Log == name {
+			report.Results = append(report.Results, res)
+		}
+	}
+	return report
+}
+
+// WriteJSON write results to json file
+func (r *Report) WriteJSON(path string) error {
+	r.lock.Lock()
+	r.End = time.Now()
+	data, err := json.MarshalIndent(r, "", "  ")
+	r.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// HumanSize 1536 -> 1.5KB
+func HumanSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	index := 0
+	for value >= 1024 && index < len(units)-1 {
+		value /= 1024
+		index++
+	}
+	if index == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.1f%v", value, units[index])
+}
+
+// DirSize total size of all files in path
+func DirSize(path string) int64 {
+	var size int64
+	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,60 @@
+package tools
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+var (
+	Retries      = 3           // 失败后的重试次数
+	RetryWait    = time.Second // 第一次重试前的等待时间, 之后每次翻倍
+	MaxRetryWait = 30 * time.Second
+)
+
+type noRetryError struct {
+	err error
+}
+
+func (e *noRetryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *noRetryError) Unwrap() error {
+	return e.err
+}
+
+// NoRetry mark err can not be fixed by retry, Retry returns it immediately
+func NoRetry(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &noRetryError{err: err}
+}
+
+// Retry run fn until it succeeds, exponential backoff with jitter between attempts
+func Retry(desc string, fn func() error) error {
+	wait := RetryWait
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var noRetry *noRetryError
+		if errors.As(err, &noRetry) {
+			return noRetry.err
+		}
+		if attempt > Retries {
+			return err
+		}
+		// 随机抖动 [wait/2, wait*3/2), 避免并发任务同时重试
+		sleep := wait/2 + time.Duration(rand.Int63n(int64(wait)+1))
+		log.Printf("[WARN] %v failed (%v/%v): %v, retry after %v", desc, attempt, Retries, err, sleep.Round(time.Millisecond))
+		time.Sleep(sleep)
+		wait *= 2
+		if wait > MaxRetryWait {
+			wait = MaxRetryWait
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SplitSize 打包文件按该大小分卷(字节), 0 不分卷
+var SplitSize int64
+
+// IndexExt 分卷索引文件的扩展名: xxx.tar.gz.index
+const IndexExt = ".index"
+
+// ParseSize 100M -> 104857600, support K/M/G/T (1024), B or no unit is bytes
+func ParseSize(raw string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(raw))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	unit := int64(1)
+	if value != "" {
+		if index := strings.IndexByte("KMGT", value[len(value)-1]); index >= 0 {
+			unit = 1 << (10 * uint(index+1))
+			value = value[:len(value)-1]
+		}
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return 0, &NewError{Msg: "invalid size: " + raw}
+	}
+	return int64(size * float64(unit)), nil
+}
+
+// CreateArchive create dest, or volumes of dest if SplitSize is set
+func CreateArchive(dest string) (io.WriteCloser, error) {
+	if SplitSize > 0 {
+		return NewSplitWriter(dest, SplitSize), nil
+	}
+	return os.Create(dest)
+}
+
+// Volume 分卷文件, name 为相对于索引文件所在目录的文件名
+type Volume struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// SplitIndex 分卷索引, volumes 按顺序拼接得到 archive
+type SplitIndex struct {
+	Archive string   `json:"archive"`
+	Size    int64    `json:"size"`
+	SHA256  string   `json:"sha256"`
+	Volumes []Volume `json:"volumes"`
+}
+
+// SplitWriter 按大小写入 dest.001 dest.002 ..., Close 时写入 dest.index
+type SplitWriter struct {
+	dest    string
+	size    int64
+	index   SplitIndex
+	file    *os.File
+	hash    hash.Hash
+	total   hash.Hash
+	written int64
+}
+
+func NewSplitWriter(dest string, size int64) *SplitWriter {
+	return &SplitWriter{
+		dest:  dest,
+		size:  size,
+		index: SplitIndex{Archive: filepath.Base(dest)},
+		total: sha256.New(),
+	}
+}
+
+func (w *SplitWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if w.file == nil || w.written >= w.size {
+			if err := w.next(); err != nil {
+				return n, err
+			}
+		}
+		chunk := p
+		if remain := w.size - w.written; int64(len(chunk)) > remain {
+			chunk = chunk[:remain]
+		}
+		m, err := w.file.Write(chunk)
+		w.hash.Write(chunk[:m])
+		w.total.Write(chunk[:m])
+		w.written += int64(m)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// next 结束当前分卷, 创建下一个分卷
+func (w *SplitWriter) next() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+	if len(w.index.Volumes) == 0 {
+		// 删除上次运行留下的分卷
+		old, _ := filepath.Glob(w.dest + ".[0-9][0-9][0-9]")
+		for _, file := range old {
+			_ = os.Remove(file)
+		}
+	}
+	name := fmt.Sprintf("%v.%03d", w.dest, len(w.index.Volumes)+1)
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w.file, w.hash, w.written = file, sha256.New(), 0
+	return nil
+}
+
+func (w *SplitWriter) finish() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.index.Volumes = append(w.index.Volumes, Volume{
+		Name:   filepath.Base(w.file.Name()),
+		Size:   w.written,
+		SHA256: hex.EncodeToString(w.hash.Sum(nil)),
+	})
+	w.index.Size += w.written
+	w.file = nil
+	return err
+}
+
+// Close 结束最后一个分卷并写入索引
+func (w *SplitWriter) Close() error {
+	if w.file == nil && len(w.index.Volumes) == 0 {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	if err := w.finish(); err != nil {
+		return err
+	}
+	w.index.SHA256 = hex.EncodeToString(w.total.Sum(nil))
+	data, err := json.MarshalIndent(w.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(w.dest+IndexExt, data, 0644)
+}
+
+// Join 校验并按索引顺序合并分卷, 返回合并后的文件
+func Join(indexFile string) (string, error) {
+	data, err := ioutil.ReadFile(indexFile)
+	if err != nil {
+		return "", err
+	}
+	var index SplitIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return "", &NewError{Msg: "invalid index " + indexFile + ": " + err.Error()}
+	}
+	dir := filepath.Dir(indexFile)
+	dest := filepath.Join(dir, filepath.Base(index.Archive))
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	total := sha256.New()
+	err = joinVolumes(io.MultiWriter(out, total), dir, index.Volumes)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && hex.EncodeToString(total.Sum(nil)) != index.SHA256 {
+		err = &NewError{Msg: "sha256 mismatch: " + index.Archive}
+	}
+	if err != nil {
+		_ = os.Remove(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+func joinVolumes(w io.Writer, dir string, volumes []Volume) error {
+	for _, volume := range volumes {
+		file, err := os.Open(filepath.Join(dir, filepath.Base(volume.Name)))
+		if err != nil {
+			return err
+		}
+		sum := sha256.New()
+		size, err := io.Copy(io.MultiWriter(w, sum), file)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+		if size != volume.Size || hex.EncodeToString(sum.Sum(nil)) != volume.SHA256 {
+			return &NewError{Msg: "volume is broken or incomplete: " + volume.Name}
+		}
+	}
+	return nil
+}
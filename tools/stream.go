@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/juju/ratelimit"
+)
+
+// Filter 写入本地文件前对数据的处理, 例如按时间过滤行
+type Filter func(reader io.Reader) io.Reader
+
+// Stream 下载数据流的限速和处理
+type Stream struct {
+	Buckets []*ratelimit.Bucket // 限速, -limit 总是生效
+	Filters []Filter            // 按顺序处理限速后的数据
+	// PerFile 下载目录时每个文件使用的数据流, 例如按文件记录脱敏次数; 为空时所有文件使用同一个
+	PerFile func(file string) Stream
+}
+
+// WithBucket copy of s with bucket added, s is not modified
+func (s Stream) WithBucket(bucket *ratelimit.Bucket) Stream {
+	if bucket != nil {
+		s.Buckets = append(s.Buckets[:len(s.Buckets):len(s.Buckets)], bucket)
+	}
+	return s
+}
+
+// WithFilter copy of s with filter added, s is not modified
+func (s Stream) WithFilter(filter Filter) Stream {
+	s.Filters = append(s.Filters[:len(s.Filters):len(s.Filters)], filter)
+	return s
+}
+
+// WithDecoder copy of s with decoder added before other filters, e.g. decompress remote data
+func (s Stream) WithDecoder(decoder Filter) Stream {
+	s.Filters = append([]Filter{decoder}, s.Filters...)
+	return s
+}
+
+// File stream of file inside a downloaded directory
+func (s Stream) File(file string) Stream {
+	if s.PerFile == nil {
+		return s
+	}
+	return s.PerFile(file)
+}
+
+// Reader limit speed of reader, then apply filters
+func (s Stream) Reader(reader io.Reader) io.Reader {
+	reader = LimitReader(reader, s.Buckets...)
+	for _, filter := range s.Filters {
+		reader = filter(reader)
+	}
+	return reader
+}
+
+// Filtered data is changed by filters, can not be verified or resumed
+func (s Stream) Filtered() bool {
+	return len(s.Filters) > 0
+}
+
+// Header filter write header before the data, e.g. source of the file
+func Header(header string) Filter {
+	return func(reader io.Reader) io.Reader {
+		return io.MultiReader(strings.NewReader(header), reader)
+	}
+}
+
+// LineReader call fn for each line (with "\n"), return nil to drop the line
+func LineReader(reader io.Reader, fn func(line []byte) []byte) io.Reader {
+	return &lineReader{reader: bufio.NewReaderSize(reader, 64*1024), fn: fn}
+}
+
+type lineReader struct {
+	reader *bufio.Reader
+	fn     func(line []byte) []byte
+	buf    []byte
+	err    error
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) > 0 {
+			r.buf = r.fn(line)
+		}
+		r.err = err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
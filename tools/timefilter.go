@@ -0,0 +1,187 @@
+package tools
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TimeFormatAuto 自动识别常见的时间格式
+const TimeFormatAuto = "auto"
+
+// 只在行首的这些字节中查找时间, 避免匹配到日志内容中的时间
+const timeSearchBytes = 128
+
+// auto 模式支持的时间格式
+var autoTimeLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+	"02/Jan/2006:15:04:05 -0700",
+	"Jan _2 15:04:05",
+	"20060102 15:04:05",
+}
+
+// Go layout 中的元素对应的正则, 按长度优先匹配
+var layoutTokens = []struct {
+	token string
+	reg   string
+}{
+	{"2006", `\d{4}`},
+	{"Z07:00", `(?:Z|[+-]\d{2}:\d{2})`},
+	{"-0700", `[+-]\d{4}`},
+	{"-07:00", `[+-]\d{2}:\d{2}`},
+	{"January", `[A-Z][a-z]+`},
+	{"Monday", `[A-Z][a-z]+`},
+	{"Jan", `[A-Z][a-z]{2}`},
+	{"Mon", `[A-Z][a-z]{2}`},
+	{"MST", `[A-Z]{3,4}`},
+	{".000000000", `\.\d{9}`},
+	{".000000", `\.\d{6}`},
+	{".000", `\.\d{3}`},
+	{"_2", `[ \d]\d`},
+	{"01", `\d{2}`},
+	{"02", `\d{2}`},
+	{"15", `\d{2}`},
+	{"04", `\d{2}`},
+	{"05", `\d{2}`},
+	{"06", `\d{2}`},
+	{"PM", `[AP]M`},
+	{"1", `\d{1,2}`},
+	{"2", `\d{1,2}`},
+	{"3", `\d{1,2}`},
+}
+
+// 没有年份或日期时用来补全的元素
+var (
+	yearTokens = map[string]bool{"2006": true, "06": true}
+	dateTokens = map[string]bool{"January": true, "Jan": true, "01": true, "1": true, "02": true, "_2": true, "2": true}
+)
+
+type timeLayout struct {
+	layout  string
+	reg     *regexp.Regexp
+	hasYear bool
+	hasDate bool
+}
+
+// parseLayout regexp matching the time written with layout, fraction of second is allowed after seconds
+func parseLayout(layout string) (timeLayout, error) {
+	result := timeLayout{layout: layout}
+	var reg strings.Builder
+	for len(layout) > 0 {
+		matched := false
+		for _, item := range layoutTokens {
+			if strings.HasPrefix(layout, item.token) {
+				reg.WriteString(item.reg)
+				if item.token == "05" && !strings.HasPrefix(layout[2:], ".0") {
+					reg.WriteString(`(?:[.,]\d+)?`)
+				}
+				result.hasYear = result.hasYear || yearTokens[item.token]
+				result.hasDate = result.hasDate || dateTokens[item.token]
+				layout = layout[len(item.token):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			reg.WriteString(regexp.QuoteMeta(layout[:1]))
+			layout = layout[1:]
+		}
+	}
+	var err error
+	result.reg, err = regexp.Compile(reg.String())
+	return result, err
+}
+
+// timeParser 从行首解析时间, auto 模式下识别到一种格式后只使用这种格式;
+// 没有年份(syslog)或日期(15:04:05)的时间补全为 ref 之前最近的一次
+type timeParser struct {
+	layouts []timeLayout
+	ref     time.Time
+}
+
+func newTimeParser(format string, ref time.Time) (*timeParser, error) {
+	parser := &timeParser{ref: ref}
+	layouts := []string{format}
+	if format == TimeFormatAuto {
+		layouts = autoTimeLayouts
+	}
+	for _, layout := range layouts {
+		item, err := parseLayout(layout)
+		if err != nil {
+			return nil, err
+		}
+		parser.layouts = append(parser.layouts, item)
+	}
+	return parser, nil
+}
+
+func (p *timeParser) parse(line []byte) (time.Time, bool) {
+	if len(line) > timeSearchBytes {
+		line = line[:timeSearchBytes]
+	}
+	for index, item := range p.layouts {
+		value := item.reg.Find(line)
+		if value == nil {
+			continue
+		}
+		t, err := time.ParseInLocation(item.layout, strings.Replace(string(value), ",", ".", 1), time.Local)
+		if err != nil {
+			continue
+		}
+		if len(p.layouts) > 1 {
+			p.layouts = []timeLayout{p.layouts[index]}
+		}
+		return p.complete(t, item), true
+	}
+	return time.Time{}, false
+}
+
+// complete 补全没有年份或日期的时间
+func (p *timeParser) complete(t time.Time, item timeLayout) time.Time {
+	clock := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	}
+	switch {
+	case !item.hasDate:
+		t = clock(p.ref.Year(), p.ref.Month(), p.ref.Day())
+		if t.After(p.ref) {
+			t = t.AddDate(0, 0, -1)
+		}
+	case !item.hasYear:
+		t = clock(p.ref.Year(), t.Month(), t.Day())
+		if t.After(p.ref) {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
+	return t
+}
+
+// TimeFilter keep lines whose time is in window, lines without time (stack trace etc.)
+// follow the previous line. format is go layout (2006-01-02 15:04:05) or auto.
+// time without year or date is taken as the latest one before window.Until (now if not set)
+func TimeFilter(window TimeWindow, format string) (Filter, error) {
+	ref := window.Until
+	if ref.IsZero() {
+		ref = time.Now()
+	}
+	ref = ref.Local()
+	if _, err := newTimeParser(format, ref); err != nil {
+		return nil, err
+	}
+	return func(reader io.Reader) io.Reader {
+		parser, _ := newTimeParser(format, ref)
+		keep := false
+		return LineReader(reader, func(line []byte) []byte {
+			if t, ok := parser.parse(line); ok {
+				keep = window.Contains(t)
+			}
+			if keep {
+				return line
+			}
+			return nil
+		})
+	}, nil
+}
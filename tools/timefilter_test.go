@@ -0,0 +1,73 @@
+package tools
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeParser(t *testing.T) {
+	ref := time.Date(2024, 3, 10, 8, 0, 0, 0, time.Local)
+	tests := []struct {
+		format string
+		line   string
+		want   time.Time
+	}{
+		{TimeFormatAuto, "2024-03-10 07:09:17,123 INFO start", time.Date(2024, 3, 10, 7, 9, 17, 123e6, time.Local)},
+		{TimeFormatAuto, "[2024/03/09 23:59:59] WARN", time.Date(2024, 3, 9, 23, 59, 59, 0, time.Local)},
+		{TimeFormatAuto, "2024-03-10T07:09:17+08:00 msg", time.Date(2024, 3, 10, 7, 9, 17, 0, time.FixedZone("", 8*3600))},
+		{TimeFormatAuto, `1.2.3.4 - - [10/Mar/2024:07:09:17 +0000] "GET /"`, time.Date(2024, 3, 10, 7, 9, 17, 0, time.UTC)},
+		// syslog: 没有年份, 使用 ref 的年份; 晚于 ref 的是去年
+		{TimeFormatAuto, "Mar 10 07:09:17 host sshd[1]: accepted", time.Date(2024, 3, 10, 7, 9, 17, 0, time.Local)},
+		{TimeFormatAuto, "Dec 31 23:00:00 host kernel: oops", time.Date(2023, 12, 31, 23, 0, 0, 0, time.Local)},
+		{"Jan _2 15:04:05", "Mar  9 07:09:17 host", time.Date(2024, 3, 9, 7, 9, 17, 0, time.Local)},
+		// 只有时间: 使用 ref 的日期; 晚于 ref 的是前一天
+		{"15:04:05", "07:09:17 inwindow", time.Date(2024, 3, 10, 7, 9, 17, 0, time.Local)},
+		{"15:04:05.000", "07:09:17.250 inwindow", time.Date(2024, 3, 10, 7, 9, 17, 250e6, time.Local)},
+		{"15:04:05", "23:00:00 yesterday", time.Date(2024, 3, 9, 23, 0, 0, 0, time.Local)},
+		{"2006-01-02 15:04:05", "no time here", time.Time{}},
+	}
+	for _, test := range tests {
+		parser, err := newTimeParser(test.format, ref)
+		if err != nil {
+			t.Fatalf("%q: %v", test.format, err)
+		}
+		got, ok := parser.parse([]byte(test.line))
+		if ok != !test.want.IsZero() || !got.Equal(test.want) {
+			t.Errorf("%q %q: got %v %v, want %v", test.format, test.line, got, ok, test.want)
+		}
+	}
+}
+
+func TestTimeFilter(t *testing.T) {
+	now := time.Now()
+	window := TimeWindow{Since: now.Add(-time.Hour), Until: now.Add(time.Hour)}
+	in := now.Add(-10 * time.Minute)
+	out := now.Add(-2 * time.Hour)
+	tests := []struct {
+		format string
+		input  string
+		want   string
+	}{
+		{"15:04:05", out.Format("15:04:05") + " old\n" + in.Format("15:04:05") + " inwindow\n\tstack\n",
+			in.Format("15:04:05") + " inwindow\n\tstack\n"},
+		{TimeFormatAuto, out.Format("Jan _2 15:04:05") + " old\n" + in.Format("Jan _2 15:04:05") + " inwindow\n",
+			in.Format("Jan _2 15:04:05") + " inwindow\n"},
+		{TimeFormatAuto, out.Format("2006-01-02 15:04:05") + " old\n" + in.Format("2006-01-02 15:04:05") + " inwindow\n",
+			in.Format("2006-01-02 15:04:05") + " inwindow\n"},
+	}
+	for _, test := range tests {
+		filter, err := TimeFilter(window, test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(filter(strings.NewReader(test.input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%q: got %q, want %q", test.format, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package tools
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// ParseTime absolute time (2006-01-02 15:04:05, 2006-01-02 15:04, 2006-01-02, RFC3339,
+// 15:04 the latest one not after now) or relative time before now (30m, 2h, 1d, 1d12h)
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, ok := parseClock(value, now); ok {
+		return t, nil
+	}
+	// 负数会指向未来
+	if d, err := ParseDuration(value); err == nil && d >= 0 && !strings.HasPrefix(value, "-") {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, &NewError{Msg: "invalid time: " + value}
+}
+
+// parseClock 只有时间(15:04:05, 15:04): 使用 now 的日期, 晚于 now 的是前一天
+func parseClock(value string, now time.Time) (time.Time, bool) {
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+			if t.After(now) {
+				t = t.AddDate(0, 0, -1)
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseDuration time.ParseDuration with day unit: 1d, 1d12h
+func ParseDuration(value string) (time.Duration, error) {
+	var days time.Duration
+	if index := strings.Index(value, "d"); index > 0 {
+		n, err := strconv.Atoi(value[:index])
+		if err != nil {
+			return 0, err
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		value = value[index+1:]
+		if value == "" {
+			return days, nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	return days + d, err
+}
+
+// TimeWindow Since/Until zero means no limit
+type TimeWindow struct {
+	Since time.Time
+	Until time.Time
+}
+
+// NewTimeWindow parse since and until, empty means no limit
+func NewTimeWindow(since, until string) (TimeWindow, error) {
+	var window TimeWindow
+	var err error
+	now := time.Now()
+	if since != "" {
+		if window.Since, err = ParseTime(since, now); err != nil {
+			return window, err
+		}
+	}
+	if until != "" {
+		if window.Until, err = ParseTime(until, now); err != nil {
+			return window, err
+		}
+		// -since 14:00 -until 15:00 在 14:30 执行: until 是 since 之后的 15:00
+		if _, ok := parseClock(strings.TrimSpace(until), now); ok && !window.Since.IsZero() && window.Until.Before(window.Since) {
+			window.Until = window.Until.AddDate(0, 0, 1)
+		}
+	}
+	if !window.Since.IsZero() && !window.Until.IsZero() && window.Until.Before(window.Since) {
+		return window, &NewError{Msg: "until is before since"}
+	}
+	return window, nil
+}
+
+func (w TimeWindow) IsZero() bool {
+	return w.Since.IsZero() && w.Until.IsZero()
+}
+
+// Contains t in window
+func (w TimeWindow) Contains(t time.Time) bool {
+	return (w.Since.IsZero() || !t.Before(w.Since)) && (w.Until.IsZero() || !t.After(w.Until))
+}
+
+// FilterFiles keep files whose content may overlap the window. Sorted by mtime,
+// each file holds the logs written after the previous file's mtime (rotated logs).
+// Files with zero mtime are kept.
+func (w TimeWindow) FilterFiles(files []string, mtimes []time.Time) []string {
+	if w.IsZero() {
+		return files
+	}
+	index := make([]int, len(files))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(i, j int) bool {
+		return mtimes[index[i]].Before(mtimes[index[j]])
+	})
+	var result []string
+	var prev time.Time
+	for _, i := range index {
+		mtime := mtimes[i]
+		if mtime.IsZero() {
+			result = append(result, files[i])
+			continue
+		}
+		if (w.Since.IsZero() || !mtime.Before(w.Since)) && (w.Until.IsZero() || prev.IsZero() || !prev.After(w.Until)) {
+			result = append(result, files[i])
+		}
+		prev = mtime
+	}
+	return result
+}
@@ -0,0 +1,37 @@
+package tools
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 8, 30, 0, 0, time.Local)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-03-09 23:59:59", time.Date(2024, 3, 9, 23, 59, 59, 0, time.Local)},
+		{"2024-03-09T12:00", time.Date(2024, 3, 9, 12, 0, 0, 0, time.Local)},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)},
+		// 只有时间: 最近一次, 不晚于 now
+		{"08:00", time.Date(2024, 3, 10, 8, 0, 0, 0, time.Local)},
+		{"08:30:00", now},
+		{"14:00", time.Date(2024, 3, 9, 14, 0, 0, 0, time.Local)},
+		{" 2h ", now.Add(-2 * time.Hour)},
+		{"1d", now.Add(-24 * time.Hour)},
+		{"1d12h", now.Add(-36 * time.Hour)},
+		{"0s", now},
+		// 负数和无效值
+		{"-2h", time.Time{}},
+		{"-1d", time.Time{}},
+		{"25:00", time.Time{}},
+		{"yesterday", time.Time{}},
+	}
+	for _, test := range tests {
+		got, err := ParseTime(test.value, now)
+		if (err != nil) != test.want.IsZero() || !got.Equal(test.want) {
+			t.Errorf("%q: got %v %v, want %v", test.value, got, err, test.want)
+		}
+	}
+}
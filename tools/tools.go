@@ -2,19 +2,18 @@ package tools
 
 import (
 	"archive/tar"
-	"compress/gzip"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/juju/ratelimit"
 	"golang.org/x/text/encoding/simplifiedchinese"
 )
 
@@ -23,6 +22,9 @@ type Charset string
 var DEBUG bool
 var Limit = 0
 
+// Resume 下载写入 .part 文件, 下次运行时从本地已下载的位置继续
+var Resume bool
+
 const (
 	UTF8    = Charset("UTF-8")
 	GB18030 = Charset("GB18030")
@@ -139,26 +141,68 @@ func Mkdir(path string) (bool, error) {
 	}
 }
 
-func LimitDownload(reader io.Reader, destDir string) error {
-	dstFile, _ := os.Create(destDir)
-	var bucket *ratelimit.Bucket
-	if Limit == 0 {
-		// max 10G ~= unlimited
-		bucket = ratelimit.NewBucketWithRate(10000*1024000, 10000*1024000)
-	} else {
-		// Bucket adding limit MB every second, limit MB
-		bucket = ratelimit.NewBucketWithRate(float64(Limit*1024000), int64(Limit*1024000))
-	}
-	_, err := io.Copy(dstFile, ratelimit.Reader(reader, bucket))
+// LimitDownload write reader to destDir, speed is limited by -limit and stream
+func LimitDownload(reader io.Reader, destDir string, stream Stream) (int64, error) {
+	return LimitDownloadAt(reader, destDir, 0, stream)
+}
+
+// LimitDownloadAt write reader to destDir from offset, data after offset is dropped
+func LimitDownloadAt(reader io.Reader, destDir string, offset int64, stream Stream) (int64, error) {
+	dstFile, err := os.OpenFile(destDir, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer func() {
 		_ = dstFile.Close()
 	}()
+	if err := dstFile.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := dstFile.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(dstFile, stream.Reader(reader))
+	if err != nil {
+		return written, err
+	}
 	// 防止本次IO还未完成,进行下一轮IO
 	time.Sleep(1000 * time.Millisecond)
-	return nil
+	return written, nil
+}
+
+// PartFiles unfinished download files in path
+func PartFiles(path string) []string {
+	var parts []string
+	_ = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(file, ".part") {
+			parts = append(parts, file)
+		}
+		return nil
+	})
+	return parts
+}
+
+// Parallel run fn(0...count-1), at most limit goroutines at the same time
+func Parallel(limit, count int, fn func(index int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < limit && i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				fn(index)
+			}
+		}()
+	}
+	for index := 0; index < count; index++ {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
 }
 
 func DeleteDir(localPath string) {
@@ -173,38 +217,43 @@ func DeleteDir(localPath string) {
 	}
 }
 
-// Compress Compress tar.gz
+// Compress 打包 files 到 dest, 格式按 dest 的扩展名: tar.gz/tar.zst/zip, 以 .age 结尾时加密, 设置了 SplitSize 时分卷; 成功后删除 files
 func Compress(files []string, dest string, isTar bool) error {
 	if isTar == false {
 		return nil
 	}
-	d, _ := os.Create(dest)
-	defer func(d *os.File) {
-		err := d.Close()
-		if err != nil {
-
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && info.IsDir() {
+			if err := WriteManifest(file); err != nil {
+				return err
+			}
 		}
-	}(d)
-	gw := gzip.NewWriter(d)
-	defer func(gw *gzip.Writer) {
-		err := gw.Close()
-		if err != nil {
-
+	}
+	d, err := CreateArchive(dest)
+	if err != nil {
+		return err
+	}
+	var w io.WriteCloser = d
+	if strings.HasSuffix(dest, EncryptExt) {
+		// 边打包边加密, 磁盘上不会有未加密的打包文件
+		if w, err = EncryptWriter(d); err != nil {
+			_ = d.Close()
+			return err
 		}
-	}(gw)
-	tw := tar.NewWriter(gw)
-	defer func(tw *tar.Writer) {
-		err := tw.Close()
-		if err != nil {
-
+	}
+	err = WriteArchive(w, files, ArchiveFormat(strings.TrimSuffix(dest, EncryptExt)))
+	if w != d {
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
 		}
-	}(tw)
+	}
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
 	for _, file := range files {
-		srcFile, _ := os.Open(file)
-		err := compress(srcFile, "", tw)
-		if err != nil {
-			return err
-		}
 		DeleteDir(file)
 	}
 	return nil
@@ -241,17 +290,8 @@ func compress(file *os.File, prefix string, tw *tar.Writer) error {
 		if err != nil {
 			return err
 		}
-		var bucket *ratelimit.Bucket
-		if Limit == 0 {
-			// max 10G ~= unlimited
-			bucket = ratelimit.NewBucketWithRate(10000*1024000, 10000*1024000)
-		} else {
-			// Bucket adding limit MB every second, limit MB
-			bucket = ratelimit.NewBucketWithRate(float64(Limit*1024000), int64(Limit*1024000))
-		}
-
 		//_, err = io.Copy(tw, file)
-		_, err = io.Copy(tw, ratelimit.Reader(file, bucket))
+		_, err = io.Copy(tw, LimitReader(file))
 		if err != nil {
 			return err
 		}
@@ -263,7 +303,7 @@ func compress(file *os.File, prefix string, tw *tar.Writer) error {
 	return nil
 }
 
-// DeCompress 解压 tar.gz
+// DeCompress 解压 tar.gz, 其他格式使用 Extract
 func DeCompress(tarFile, dest string) error {
 	srcFile, err := os.Open(tarFile)
 	if err != nil {
@@ -275,68 +315,5 @@ func DeCompress(tarFile, dest string) error {
 
 		}
 	}(srcFile)
-	gr, err := gzip.NewReader(srcFile)
-	if err != nil {
-		return err
-	}
-	defer func(gr *gzip.Reader) {
-		err := gr.Close()
-		if err != nil {
-
-		}
-	}(gr)
-	tr := tar.NewReader(gr)
-	for {
-		hdr, err := tr.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return err
-			}
-		}
-		filename := dest + hdr.Name
-		file, err := createFile(filename)
-		if err != nil {
-			return err
-		}
-		io.Copy(file, tr)
-	}
-	return nil
-}
-
-func createFile(name string) (*os.File, error) {
-	err := os.MkdirAll(string([]rune(name)[0:strings.LastIndex(name, "/")]), 0755)
-	if err != nil {
-		return nil, err
-	}
-	return os.Create(name)
-}
-
-func KubectlLogs(ns, podPreFix, container, num, destDir string) error {
-	getPodCmd := fmt.Sprintf("kubectl -n %s get pod|grep '%s'|awk '{print $1}'", ns, podPreFix)
-	allPodStr, err := Run(getPodCmd)
-	if err != nil {
-		return &NewError{Msg: allPodStr}
-	}
-	if allPodStr == "" {
-		return &NewError{Msg: "Pod not found"}
-	}
-	podList := strings.Split(allPodStr, "\n")
-	for _, pod := range podList {
-		destFile := fmt.Sprintf("%s/%s-pod.log", destDir, pod)
-		log.Println(fmt.Sprintf("[INFO] Download %s to %s ", pod, destFile))
-		var cmd string
-		if container != "" {
-			cmd = fmt.Sprintf("kubectl -n %s logs --tail %s %s -c %s > %s", ns, num, pod, container, destFile)
-		} else {
-			cmd = fmt.Sprintf("kubectl -n %s logs --tail %s %s > %s", ns, num, pod, destFile)
-		}
-		_, err := Run(cmd)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return unTar(srcFile, dest, FormatTarGz)
 }